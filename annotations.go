@@ -0,0 +1,233 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/google/uuid"
+)
+
+// ErrAnnotationNotExists is returned if an annotation is not in the store.
+var ErrAnnotationNotExists = errors.New("annotation does not exist")
+
+// annotationsTable stores the annotations of all elements.
+const annotationsTable = "_annotations"
+
+// Annotation is an analyst note on an element. Annotations are stored
+// separately, so the element itself is not modified.
+type Annotation struct {
+	ID        string    `json:"id"`
+	ElementID string    `json:"element_id"`
+	Author    string    `json:"author"`
+	Note      string    `json:"note"`
+	Time      time.Time `json:"time"`
+}
+
+// AddAnnotation adds a note to an element and returns the id of the
+// annotation.
+func (store *ForensicStore) AddAnnotation(elementID, author, note string) (id string, err error) {
+	id = "annotation--" + uuid.New().String()
+	err = store.WithTx(func(tx *ForensicStore) error {
+		if _, err := tx.Get(elementID); err != nil {
+			return err
+		}
+
+		return insertAnnotation(tx.connection, Annotation{
+			ID:        id,
+			ElementID: elementID,
+			Author:    author,
+			Note:      note,
+			Time:      time.Now().UTC(),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Annotations returns the annotations of an element ordered by time.
+func (store *ForensicStore) Annotations(elementID string) ([]Annotation, error) {
+	return store.AnnotationsContext(context.Background(), elementID)
+}
+
+// AnnotationsContext is like Annotations, but aborts if ctx is done.
+func (store *ForensicStore) AnnotationsContext(ctx context.Context, elementID string) (annotations []Annotation, err error) { // nolint:lll
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	annotations = []Annotation{}
+	exists, err := tableExists(conn, annotationsTable)
+	if err != nil || !exists {
+		return annotations, err
+	}
+
+	stmt, err := conn.Prepare("SELECT id, element_id, author, note, time FROM " + annotationsTable + " " +
+		"WHERE element_id = $element_id ORDER BY time, id")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Reset() // nolint:errcheck
+	stmt.SetText("$element_id", elementID)
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			return annotations, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, stmt.GetText("time"))
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, Annotation{
+			ID:        stmt.GetText("id"),
+			ElementID: stmt.GetText("element_id"),
+			Author:    stmt.GetText("author"),
+			Note:      stmt.GetText("note"),
+			Time:      t,
+		})
+	}
+}
+
+// DeleteAnnotation removes an annotation.
+func (store *ForensicStore) DeleteAnnotation(id string) error {
+	return store.WithTx(func(tx *ForensicStore) error {
+		exists, err := tableExists(tx.connection, annotationsTable)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrAnnotationNotExists
+		}
+
+		stmt, err := tx.connection.Prepare("DELETE FROM " + annotationsTable + " WHERE id = $id")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$id", id)
+		if _, err := stmt.Step(); err != nil {
+			return err
+		}
+		if tx.connection.Changes() == 0 {
+			return fmt.Errorf("%w: %s", ErrAnnotationNotExists, id)
+		}
+		return stmt.Reset()
+	})
+}
+
+// WithAnnotations returns a copy of the element with its annotations in the
+// "annotations" field. The element is returned unchanged if it has no
+// annotations.
+func (store *ForensicStore) WithAnnotations(element JSONElement) (JSONElement, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(element, &fields); err != nil {
+		return nil, err
+	}
+	id, ok := fields["id"].(string)
+	if !ok {
+		return element, nil
+	}
+
+	annotations, err := store.Annotations(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(annotations) == 0 {
+		return element, nil
+	}
+	fields["annotations"] = annotations
+	return json.Marshal(fields)
+}
+
+// insertAnnotation creates the annotations table if required and inserts an
+// annotation. Annotations with an existing id are ignored.
+func insertAnnotation(conn *sqlite.Conn, annotation Annotation) error {
+	err := sqlitex.Exec(conn, "CREATE TABLE IF NOT EXISTS "+annotationsTable+" ("+
+		"id TEXT NOT NULL PRIMARY KEY, element_id TEXT NOT NULL, author TEXT, note TEXT, time TEXT)", nil)
+	if err != nil {
+		return err
+	}
+	err = sqlitex.Exec(conn, "CREATE INDEX IF NOT EXISTS _annotations_element_index ON "+
+		annotationsTable+"(element_id)", nil)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := conn.Prepare("INSERT OR IGNORE INTO " + annotationsTable + " (id, element_id, author, note, time) " +
+		"VALUES ($id, $element_id, $author, $note, $time)")
+	if err != nil {
+		return err
+	}
+	stmt.SetText("$id", annotation.ID)
+	stmt.SetText("$element_id", annotation.ElementID)
+	stmt.SetText("$author", annotation.Author)
+	stmt.SetText("$note", annotation.Note)
+	stmt.SetText("$time", annotation.Time.UTC().Format(time.RFC3339Nano))
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	return stmt.Reset()
+}
+
+// deleteAnnotations removes all annotations of an element.
+func deleteAnnotations(conn *sqlite.Conn, elementID string) error {
+	exists, err := tableExists(conn, annotationsTable)
+	if err != nil || !exists {
+		return err
+	}
+
+	stmt, err := conn.Prepare("DELETE FROM " + annotationsTable + " WHERE element_id = $element_id")
+	if err != nil {
+		return err
+	}
+	stmt.SetText("$element_id", elementID)
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	return stmt.Reset()
+}
+
+func tableExists(conn *sqlite.Conn, name string) (bool, error) {
+	stmt, err := conn.Prepare("SELECT count(*) AS count FROM sqlite_master WHERE type = 'table' AND name = $name")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Reset() // nolint:errcheck
+	stmt.SetText("$name", name)
+	if _, err := stmt.Step(); err != nil {
+		return false, err
+	}
+	return stmt.GetInt64("count") > 0, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestForensicStore_Annotations(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	annotations, err := store.Annotations(ProcessElementId)
+	assert.NoError(t, err)
+	assert.Empty(t, annotations)
+
+	first, err := store.AddAnnotation(ProcessElementId, "alice", "looks suspicious")
+	assert.NoError(t, err)
+	second, err := store.AddAnnotation(ProcessElementId, "bob", "confirmed, it's malware")
+	assert.NoError(t, err)
+	_, err = store.AddAnnotation(fooDocID, "alice", "benign")
+	assert.NoError(t, err)
+
+	_, err = store.AddAnnotation("file--00000000-0000-0000-0000-000000000000", "alice", "missing")
+	assert.True(t, errors.Is(err, ErrElementNotExists))
+
+	annotations, err = store.Annotations(ProcessElementId)
+	assert.NoError(t, err)
+	if assert.Len(t, annotations, 2) {
+		assert.Equal(t, first, annotations[0].ID)
+		assert.Equal(t, ProcessElementId, annotations[0].ElementID)
+		assert.Equal(t, "alice", annotations[0].Author)
+		assert.Equal(t, "looks suspicious", annotations[0].Note)
+		assert.False(t, annotations[0].Time.IsZero())
+		assert.Equal(t, "confirmed, it's malware", annotations[1].Note)
+	}
+
+	// the element itself is not modified
+	element, err := store.Get(ProcessElementId)
+	assert.NoError(t, err)
+	assert.False(t, gjson.GetBytes(element, "annotations").Exists())
+
+	annotated, err := store.WithAnnotations(element)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", gjson.GetBytes(annotated, "annotations.1.author").String())
+	assert.Equal(t, "iptables", gjson.GetBytes(annotated, "name").String())
+
+	assert.NoError(t, store.DeleteAnnotation(second))
+	assert.True(t, errors.Is(store.DeleteAnnotation(second), ErrAnnotationNotExists))
+
+	annotations, err = store.Annotations(ProcessElementId)
+	assert.NoError(t, err)
+	assert.Len(t, annotations, 1)
+
+	// annotations are deleted with the element
+	assert.NoError(t, store.Delete(fooDocID, false))
+	annotations, err = store.Annotations(fooDocID)
+	assert.NoError(t, err)
+	assert.Empty(t, annotations)
+}
+
+func TestForensicStore_DeleteAnnotation_noTable(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	err := store.DeleteAnnotation("annotation--00000000-0000-0000-0000-000000000000")
+	assert.True(t, errors.Is(err, ErrAnnotationNotExists))
+}
@@ -0,0 +1,456 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/spf13/afero"
+
+	"github.com/forensicanalysis/forensicstore/sqlitefs"
+)
+
+// auditTable stores the audit log.
+const auditTable = "_audit"
+
+// ErrNoAuditLog is returned if a store of version 3 or before has not been
+// upgraded yet and therefore has no audit log.
+var ErrNoAuditLog = errors.New("store has no audit log, upgrade the store to create it")
+
+// Operations recorded in the audit log.
+const (
+	AuditBaseline   = "baseline"
+	AuditInsert     = "insert"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditStoreFile  = "store_file"
+	AuditRemoveFile = "remove_file"
+)
+
+// AuditEntry is a single record of the audit log. Hashes are hex encoded
+// SHA-256 hashes of the element JSON or the file content before and after the
+// operation.
+type AuditEntry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Operation  string    `json:"operation"`
+	ElementID  string    `json:"element_id,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	BeforeHash string    `json:"before_hash,omitempty"`
+	AfterHash  string    `json:"after_hash,omitempty"`
+	// Chain is the hash of the previous Chain and this entry.
+	Chain string `json:"chain"`
+}
+
+// SetUser sets the user that is recorded in the audit log. It defaults to the
+// user running the process.
+func (store *ForensicStore) SetUser(name string) {
+	store.user = name
+}
+
+// AuditLog returns all entries of the audit log in the order they were
+// recorded.
+func (store *ForensicStore) AuditLog() ([]AuditEntry, error) {
+	return store.AuditLogContext(context.Background())
+}
+
+// AuditLogContext is like AuditLog, but aborts if ctx is done.
+func (store *ForensicStore) AuditLogContext(ctx context.Context) (entries []AuditEntry, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	exists, err := tableExists(conn, auditTable)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoAuditLog
+	}
+
+	stmt, _, err := conn.PrepareTransient("SELECT seq, time, user, operation, element_id, path, before_hash, after_hash, chain " +
+		"FROM " + auditTable + " ORDER BY seq")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize() // nolint:errcheck
+
+	entries = []AuditEntry{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			return entries, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, stmt.GetText("time"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, AuditEntry{
+			Seq:        stmt.GetInt64("seq"),
+			Time:       t,
+			User:       stmt.GetText("user"),
+			Operation:  stmt.GetText("operation"),
+			ElementID:  stmt.GetText("element_id"),
+			Path:       stmt.GetText("path"),
+			BeforeHash: stmt.GetText("before_hash"),
+			AfterHash:  stmt.GetText("after_hash"),
+			Chain:      stmt.GetText("chain"),
+		})
+	}
+}
+
+// VerifyAudit checks that the current elements and files match the last
+// state recorded in the audit log and returns all differences.
+//
+// Elements are recorded in the same transaction as they are changed. Files
+// stored with StoreFile are recorded when they are closed. The record is
+// written in the same transaction as the file content, except for pooled
+// stores and directory stores, where a crash between the file and the record
+// leaves a file that is not in the audit log. Files written or removed through
+// Fs directly, e.g. by "forensicstore pack", are not recorded. VerifyAudit only
+// checks recorded files, VerifyIntegrity also reports files that are not in
+// the audit log.
+func (store *ForensicStore) VerifyAudit() (flaws []string, err error) {
+	return store.VerifyAuditContext(context.Background())
+}
+
+// VerifyAuditContext is like VerifyAudit, but aborts if ctx is done.
+func (store *ForensicStore) VerifyAuditContext(ctx context.Context) (flaws []string, err error) {
+	entries, err := store.AuditLogContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// last recorded hash of every element and file, "" if deleted
+	elementHashes := map[string]string{}
+	fileHashes := map[string]string{}
+	for _, entry := range entries {
+		if entry.Path != "" {
+			fileHashes[normalizePath(entry.Path)] = entry.AfterHash
+		} else {
+			elementHashes[entry.ElementID] = entry.AfterHash
+		}
+	}
+
+	flaws = []string{}
+	seen := map[string]bool{}
+	rows, err := store.queryRows(ctx, "SELECT json, id FROM elements ORDER BY id", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		id := rows.stmt.GetText("id")
+		seen[id] = true
+		recorded, ok := elementHashes[id]
+		switch {
+		case !ok:
+			flaws = append(flaws, fmt.Sprintf("element %s is not in the audit log", id))
+		case recorded == "":
+			flaws = append(flaws, fmt.Sprintf("element %s was deleted according to the audit log", id))
+		case recorded != hashBytes(rows.Element()):
+			flaws = append(flaws, fmt.Sprintf("element %s was modified outside of the audit log", id))
+		}
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	for id, recorded := range elementHashes {
+		if recorded != "" && !seen[id] {
+			flaws = append(flaws, fmt.Sprintf("element %s is missing", id))
+		}
+	}
+
+	for path, recorded := range fileHashes {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		current, err := hashFile(store.Fs, path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case recorded == "" && current != "":
+			flaws = append(flaws, fmt.Sprintf("file %s was removed according to the audit log", path))
+		case recorded != "" && current == "":
+			flaws = append(flaws, fmt.Sprintf("file %s is missing", path))
+		case recorded != current:
+			flaws = append(flaws, fmt.Sprintf("file %s was modified outside of the audit log", path))
+		}
+	}
+	return flaws, nil
+}
+
+// setupAudit creates the audit table. It is only called by New and Upgrade,
+// opening a store never changes its schema. If the store already contains
+// elements and files, their current state is recorded as baseline. The
+// baseline is trusted, so changes before the upgrade can not be detected.
+func (store *ForensicStore) setupAudit() (err error) {
+	conn, release, err := store.acquire(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	exists, err := tableExists(conn, auditTable)
+	if err != nil || exists {
+		return err
+	}
+
+	defer sqlitex.Save(conn)(&err)
+
+	for _, query := range []string{
+		"CREATE TABLE " + auditTable + " (" +
+			"seq INTEGER PRIMARY KEY AUTOINCREMENT, time TEXT NOT NULL, user TEXT, operation TEXT NOT NULL, " +
+			"element_id TEXT, path TEXT, before_hash TEXT, after_hash TEXT, chain TEXT NOT NULL)",
+		"CREATE TRIGGER audit_no_update BEFORE UPDATE ON " + auditTable +
+			" BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END",
+		"CREATE TRIGGER audit_no_delete BEFORE DELETE ON " + auditTable +
+			" BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END",
+	} {
+		if err := sqlitex.ExecTransient(conn, query, nil); err != nil {
+			return err
+		}
+	}
+
+	err = sqlitex.ExecTransient(conn, "SELECT id, json FROM elements ORDER BY id", func(stmt *sqlite.Stmt) error {
+		return store.auditElement(conn, AuditBaseline, stmt.GetText("id"), nil, []byte(stmt.GetText("json")))
+	})
+	if err != nil {
+		return err
+	}
+
+	files, err := store.storedFiles(conn)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		fileHash, err := hashFile(store.Fs, path)
+		if err != nil {
+			return err
+		}
+		if err := store.audit(conn, AuditBaseline, "", path, "", fileHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditElement records an operation on an element. before and after are nil
+// if the element did not exist before or after the operation.
+func (store *ForensicStore) auditElement(conn *sqlite.Conn, operation, id string, before, after []byte) error {
+	return store.audit(conn, operation, id, "", hashBytes(before), hashBytes(after))
+}
+
+// audit appends an entry to the audit log and extends the hash chain. Stores
+// without audit log, i.e. stores of version 3 and before that are not
+// upgraded yet, are not audited.
+func (store *ForensicStore) audit(conn *sqlite.Conn, operation, id, path, beforeHash, afterHash string) error {
+	exists, err := tableExists(conn, auditTable)
+	if err != nil || !exists {
+		return err
+	}
+
+	head, err := chainHead(conn)
+	if err != nil {
+		return err
+	}
+
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		User:       store.user,
+		Operation:  operation,
+		ElementID:  id,
+		Path:       path,
+		BeforeHash: beforeHash,
+		AfterHash:  afterHash,
+	}
+	chain, err := entry.chain(head)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := conn.Prepare("INSERT INTO " + auditTable + " (time, user, operation, element_id, path, before_hash, after_hash, chain) " +
+		"VALUES ($time, $user, $operation, $element_id, $path, $before_hash, $after_hash, $chain)")
+	if err != nil {
+		return err
+	}
+	stmt.SetText("$time", entry.Time.Format(time.RFC3339Nano))
+	stmt.SetText("$user", entry.User)
+	stmt.SetText("$operation", entry.Operation)
+	setOptionalText(stmt, "$element_id", entry.ElementID)
+	setOptionalText(stmt, "$path", entry.Path)
+	setOptionalText(stmt, "$before_hash", entry.BeforeHash)
+	setOptionalText(stmt, "$after_hash", entry.AfterHash)
+	stmt.SetText("$chain", chain)
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	return stmt.Reset()
+}
+
+func setOptionalText(stmt *sqlite.Stmt, param, value string) {
+	if value == "" {
+		stmt.SetNull(param)
+	} else {
+		stmt.SetText(param, value)
+	}
+}
+
+// auditFile records a file written by StoreFile, when it is closed. The
+// SHA-256 hash of the audit log is passed on to hashed, if set.
+type auditFile struct {
+	afero.File
+	store  *ForensicStore
+	path   string
+	hash   hash.Hash
+	hashed *HashedFile
+	closed bool
+}
+
+func (f *auditFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.hash.Write(p[:n]) // nolint:errcheck
+	if f.hashed != nil {
+		f.hashed.write(p[:n])
+	}
+	return n, err
+}
+
+func (f *auditFile) Close() (err error) {
+	if f.closed {
+		return f.File.Close()
+	}
+
+	// stores that are not pooled write the file content with their only
+	// connection, so the content and the record share a transaction
+	_, isSQLiteFS := f.store.Fs.(*sqlitefs.FS)
+	sameTx := isSQLiteFS && f.store.pool == nil
+	if !sameTx {
+		if err := f.File.Close(); err != nil {
+			return err
+		}
+	}
+
+	conn, release, err := f.store.acquire(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+	if sameTx {
+		defer sqlitex.Save(conn)(&err)
+		if err := f.File.Close(); err != nil {
+			return err
+		}
+	}
+
+	f.closed = true
+	sum := fmt.Sprintf("%x", f.hash.Sum(nil))
+	if f.hashed != nil {
+		f.hashed.close(sum)
+	}
+	return f.store.audit(conn, AuditStoreFile, "", f.path, "", sum)
+}
+
+// storedFiles returns the normalized paths of all files. Files in the sqlite
+// archive are listed directly, as directories are optional in sqlar.
+func (store *ForensicStore) storedFiles(conn *sqlite.Conn) ([]string, error) {
+	var files []string
+	if _, ok := store.Fs.(*sqlitefs.FS); ok {
+		err := sqlitex.ExecTransient(conn, "SELECT name FROM sqlar WHERE data IS NOT NULL OR sz != 0 ORDER BY name",
+			func(stmt *sqlite.Stmt) error {
+				files = append(files, normalizePath(stmt.GetText("name")))
+				return nil
+			})
+		return files, err
+	}
+
+	err := afero.Walk(store.Fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, normalizePath(path))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// normalizePath converts paths like "dir/file" to "/dir/file".
+func normalizePath(path string) string {
+	return "/" + strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+func hashBytes(b []byte) string {
+	if b == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// hashFile returns the hash of a file or "" if it does not exist.
+func hashFile(fs afero.Fs, path string) (string, error) {
+	f, err := fs.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func currentUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return u.Username
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func operations(entries []AuditEntry) []string {
+	var ops []string
+	for _, entry := range entries {
+		ops = append(ops, entry.Operation+" "+entry.ElementID+entry.Path)
+	}
+	return ops
+}
+
+func TestForensicStore_AuditLog(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	entries, err := store.AuditLog()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 7)
+
+	store.SetUser("alice")
+
+	id, err := store.Insert(jsons(element{"type": "foo", "name": "bar"}))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Update(id, jsons(element{"type": "foo", "name": "baz"})))
+	assert.NoError(t, store.Delete("process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d", true))
+
+	storePath, w, teardownFile, err := store.StoreFile("/foo/bar.txt")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, teardownFile())
+
+	entries, err = store.AuditLog()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"insert " + id,
+		"update " + id,
+		"remove_file WMILogicalDisks/stderr",
+		"remove_file WMILogicalDisks/stdout",
+		"delete process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d",
+		"store_file " + storePath,
+	}, sortRemovals(operations(entries[7:])))
+
+	for _, entry := range entries[7:] {
+		assert.Equal(t, "alice", entry.User)
+		assert.False(t, entry.Time.IsZero())
+	}
+	assert.Equal(t, entries[7].AfterHash, entries[8].BeforeHash)
+	assert.NotEqual(t, entries[8].BeforeHash, entries[8].AfterHash)
+	assert.Empty(t, entries[11].AfterHash)
+	assert.Equal(t, hashBytes([]byte("hello")), entries[12].AfterHash)
+
+	flaws, err := store.VerifyAudit()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
+
+// sortRemovals sorts the file removals of a single delete, as they are
+// removed in map order.
+func sortRemovals(ops []string) []string {
+	if ops[2] > ops[3] {
+		ops[2], ops[3] = ops[3], ops[2]
+	}
+	return ops
+}
+
+func TestForensicStore_AuditLog_appendOnly(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	assert.Error(t, store.exec("UPDATE _audit SET user = 'mallory'"))
+	assert.Error(t, store.exec("DELETE FROM _audit"))
+
+	entries, err := store.AuditLog()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 7)
+}
+
+func TestForensicStore_VerifyAudit(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	_, w, teardownFile, err := store.StoreFile("/foo.txt")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, teardownFile())
+
+	// changes that bypass the API are not recorded
+	assert.NoError(t, store.exec("UPDATE elements SET json = json_set(json, '$.name', 'x') WHERE id = '"+ProcessElementId+"'"))
+	assert.NoError(t, store.exec("DELETE FROM elements WHERE id = '"+fooDocID+"'"))
+	assert.NoError(t, store.exec("INSERT INTO elements (id, json) VALUES ('foo--1', '{}')"))
+	assert.NoError(t, store.exec("DELETE FROM sqlar WHERE name LIKE '%foo.txt'"))
+
+	flaws, err := store.VerifyAudit()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"element " + ProcessElementId + " was modified outside of the audit log",
+		"element " + fooDocID + " is missing",
+		"element foo--1 is not in the audit log",
+		"file /foo.txt is missing",
+	}, flaws)
+}
+
+func TestForensicStore_setupAudit_baseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := newVersion3Store(t, dir)
+
+	store, teardown, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.Fs.Create("/foo.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, teardown())
+
+	store, teardown, err = Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	// opening does not create the audit log
+	exists, err := tableExists(store.connection, auditTable)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = store.AuditLog()
+	assert.ErrorIs(t, err, ErrNoAuditLog)
+	_, err = store.VerifyIntegrity()
+	assert.ErrorIs(t, err, ErrNoAuditLog)
+
+	_, err = store.Upgrade()
+	assert.NoError(t, err)
+
+	entries, err := store.AuditLog()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"baseline foo--1", "baseline /foo.txt"}, operations(entries))
+
+	flaws, err := store.VerifyIntegrity()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
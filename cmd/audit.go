@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Audit is the forensicstore audit commandline subcommand.
+func Audit() *cobra.Command {
+	var verify bool
+	auditCommand := &cobra.Command{
+		Use:   "audit <forensicstore>",
+		Short: "Print or verify the audit log",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			storeName := cmd.Flags().Args()[0]
+			store, teardown, err := openStore(storeName)
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			if verify {
+				flaws, err := store.VerifyAudit()
+				if err != nil {
+					return err
+				}
+				if len(flaws) > 0 {
+					return printJSON(flaws, errors.New("audit log verification failed"))
+				}
+				return nil
+			}
+
+			entries, err := store.AuditLog()
+			if err != nil {
+				return err
+			}
+			return printJSON(entries, nil)
+		},
+	}
+	auditCommand.Flags().BoolVar(&verify, "verify", false, "verify the store against the audit log")
+	return auditCommand
+}
+
+// printJSON prints v as JSON and returns err.
+func printJSON(v interface{}, err error) error {
+	b, jsonErr := json.Marshal(v)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	fmt.Printf("%s\n", b)
+	return err
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// setupAudited is like setup, but upgrades the store, so it has an audit log.
+func setupAudited(t *testing.T) (string, string) {
+	dir, storePath := setup(t)
+	store, teardown, err := forensicstore.Open(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+	if _, err := store.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	return dir, storePath
+}
+
+func Test_auditCommand(t *testing.T) {
+	dir, storePath := setupAudited(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		args     []string
+		contains string
+		wantErr  bool
+	}{
+		{"audit", []string{storePath}, `"operation":"baseline"`, false},
+		{"verify", []string{"--verify", storePath}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Audit()
+			if err := cmd.Flags().Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			output := stdout(func() {
+				err := cmd.RunE(cmd, cmd.Flags().Args())
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Audit() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+
+			if !bytes.Contains(output, []byte(tt.contains)) {
+				t.Errorf("Audit() got = %s, want %s", output, tt.contains)
+			}
+		})
+	}
+
+	store, teardown, err := forensicstore.Open(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sqlitex.ExecTransient(store.Connection(), "DELETE FROM elements WHERE id = 'directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7'", nil) // nolint:lll
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := Audit()
+	if err := cmd.Flags().Parse([]string{"--verify", storePath}); err != nil {
+		t.Fatal(err)
+	}
+	output := stdout(func() {
+		if err := cmd.RunE(cmd, cmd.Flags().Args()); err == nil {
+			t.Error("Audit() expected verification error")
+		}
+	})
+	if !bytes.Contains(output, []byte("directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7 is missing")) {
+		t.Errorf("Audit() got = %s", output)
+	}
+}
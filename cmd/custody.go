@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// Custody is the forensicstore custody commandline subcommand.
+func Custody() *cobra.Command {
+	custodyCommand := &cobra.Command{
+		Use:   "custody",
+		Short: "Show or add chain of custody information",
+	}
+	custodyCommand.AddCommand(custodyShowCommand(), custodyAddCommand(), custodySetCommand())
+	return custodyCommand
+}
+
+func custodyShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <forensicstore>",
+		Short: "Show the metadata and the chain of custody",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, teardown, err := openStore(cmd.Flags().Args()[0])
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			metadata, err := store.Metadata()
+			if err != nil {
+				return err
+			}
+			events, err := store.CustodyEvents()
+			if err != nil {
+				return err
+			}
+			return printJSON(map[string]interface{}{"metadata": metadata, "custody": events}, nil)
+		},
+	}
+}
+
+func custodyAddCommand() *cobra.Command {
+	var event forensicstore.CustodyEvent
+	addCommand := &cobra.Command{
+		Use:   "add <action> <forensicstore>",
+		Short: "Add an event to the chain of custody",
+		Args:  cobra.ExactArgs(2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args = cmd.Flags().Args()
+			store, teardown, err := openStore(args[1])
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			event.Action = args[0]
+			_, err = store.AddCustodyEvent(event)
+			return err
+		},
+	}
+	addCommand.Flags().StringVar(&event.From, "from", "", "person or organization handing over the store")
+	addCommand.Flags().StringVar(&event.To, "to", "", "person or organization receiving the store")
+	addCommand.Flags().StringVar(&event.Note, "note", "", "additional note")
+	return addCommand
+}
+
+func custodySetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <key> <value> <forensicstore>",
+		Short: "Set a metadata value like the case number",
+		Args:  cobra.ExactArgs(3), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args = cmd.Flags().Args()
+			store, teardown, err := openStore(args[2])
+			if err != nil {
+				return err
+			}
+			defer teardown()
+			return store.SetMetadata(args[0], args[1])
+		},
+	}
+}
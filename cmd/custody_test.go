@@ -0,0 +1,67 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_custodyCommands(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		cmd     func() *cobra.Command
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"show empty", custodyShowCommand, []string{storePath}, `^{"custody":\[\],"metadata":{}}\n$`, false},
+		{"set", custodySetCommand, []string{"case_number", "2020-001", storePath}, `^$`, false},
+		{"add", custodyAddCommand, []string{"--to", "alice", "acquired", storePath}, `^$`, false},
+		{"add without action", custodyAddCommand, []string{"", storePath}, `^$`, true},
+		{"show", custodyShowCommand, []string{storePath},
+			`^{"custody":\[{"seq":1,"time":"[^"]+","action":"acquired","to":"alice"}\],"metadata":{"case_number":"2020-001"}}\n$`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.cmd()
+			cmd.Flags().Parse(tt.args) // nolint:errcheck
+
+			output := stdout(func() {
+				err := cmd.RunE(cmd, cmd.Flags().Args())
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%s error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				}
+			})
+
+			if !regexp.MustCompile(tt.want).Match(output) {
+				t.Errorf("%s got = %s, want %s", tt.name, output, tt.want)
+			}
+		})
+	}
+}
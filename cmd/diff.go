@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// Diff is the forensicstore diff commandline subcommand.
+func Diff() *cobra.Command {
+	var jsonOutput bool
+	var keys map[string]string
+	diffCommand := &cobra.Command{
+		Use:   "diff <forensicstore> <forensicstore>",
+		Short: "Show added, removed and changed elements and files",
+		Args:  cobra.ExactArgs(2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			args = cmd.Flags().Args()
+
+			a, teardownA, err := openStore(args[0])
+			if err != nil {
+				return err
+			}
+			defer teardownA()
+			b, teardownB, err := openStore(args[1])
+			if err != nil {
+				return err
+			}
+			defer teardownB()
+
+			options := &forensicstore.DiffOptions{Keys: map[string]string{}}
+			for elementType, field := range forensicstore.DefaultDiffKeys {
+				options.Keys[elementType] = field
+			}
+			for elementType, field := range keys {
+				options.Keys[elementType] = field
+			}
+
+			diff, err := forensicstore.Diff(a, b, options)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(diff, nil)
+			}
+			printDiff(diff)
+			return nil
+		},
+	}
+	diffCommand.Flags().BoolVar(&jsonOutput, "json", false, "print as json")
+	diffCommand.Flags().StringToStringVar(&keys, "key", nil,
+		"field to match elements of a type, e.g. --key process=name (empty to match by id)")
+	return diffCommand
+}
+
+func printDiff(diff *forensicstore.StoreDiff) {
+	for _, change := range diff.RemovedElements {
+		fmt.Printf("- element %s %s\n", change.Type, change.Key)
+	}
+	for _, change := range diff.AddedElements {
+		fmt.Printf("+ element %s %s\n", change.Type, change.Key)
+	}
+	for _, change := range diff.ChangedElements {
+		fmt.Printf("~ element %s %s (%s)\n", change.Type, change.Key, strings.Join(change.Fields, ", "))
+	}
+	for _, change := range diff.RemovedFiles {
+		fmt.Printf("- file %s\n", change.Path)
+	}
+	for _, change := range diff.AddedFiles {
+		fmt.Printf("+ file %s\n", change.Path)
+	}
+	for _, change := range diff.ChangedFiles {
+		fmt.Printf("~ file %s\n", change.Path)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func Test_diffCommand(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	b, err := ioutil.ReadFile(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(dir, "example2.forensicstore")
+	if err := ioutil.WriteFile(otherPath, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, teardown, err := forensicstore.Open(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processID := "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d"
+	if err := store.Patch(processID, forensicstore.JSONElement(`{"cwd": "/tmp/"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"text", []string{storePath, otherPath}, "~ element process " + processID + " (cwd)\n"},
+		{"json", []string{"--json", storePath, otherPath}, `"changed_elements":[{"type":"process","key":"` + processID + `",`},
+		{"key", []string{"--key", "process=name", storePath, otherPath}, "~ element process powershell (cwd)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Diff()
+			cmd.Flags().Parse(tt.args) // nolint:errcheck
+			output := stdout(func() {
+				if err := cmd.RunE(cmd, cmd.Flags().Args()); err != nil {
+					t.Error(err)
+				}
+			})
+			if !strings.Contains(string(output), tt.want) {
+				t.Errorf("Diff() got = %s, want %s", output, tt.want)
+			}
+		})
+	}
+}
@@ -30,14 +30,15 @@ import (
 )
 
 func getCommand() *cobra.Command {
-	return &cobra.Command{
+	var annotations bool
+	getCommand := &cobra.Command{
 		Use:   "get <id> <forensicstore>",
 		Short: "Retrieve a single element",
 		Args:  cobra.ExactArgs(2), //nolint:gomnd
 		RunE: func(cmd *cobra.Command, args []string) error {
 			id := cmd.Flags().Args()[0]
 			storeName := cmd.Flags().Args()[1]
-			store, teardown, err := forensicstore.Open(storeName)
+			store, teardown, err := openStore(storeName)
 			if err != nil {
 				return err
 			}
@@ -46,55 +47,67 @@ func getCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			if annotations {
+				elements, err = store.WithAnnotations(elements)
+				if err != nil {
+					return err
+				}
+			}
 			fmt.Printf("%s\n", elements)
 			return nil
 		},
 	}
+	getCommand.Flags().BoolVar(&annotations, "annotations", false, "include annotations")
+	return getCommand
 }
 
 func selectCommand() *cobra.Command {
-	return &cobra.Command{
+	var annotations bool
+	selectCommand := &cobra.Command{
 		Use:   "select <type> <forensicstore>",
 		Short: "Retrieve a list of all elements of a specific type",
 		Args:  cobra.ExactArgs(2), //nolint:gomnd
 		RunE: func(cmd *cobra.Command, args []string) error {
 			elementType := cmd.Flags().Args()[0]
 			storeName := cmd.Flags().Args()[1]
-			store, teardown, err := forensicstore.Open(storeName)
+			store, teardown, err := openStore(storeName)
 			if err != nil {
 				return err
 			}
 			defer teardown()
-			elements, err := store.Select([]map[string]string{{"type": elementType}})
+			rows, err := store.SelectRows([]map[string]string{{"type": elementType}})
 			if err != nil {
 				return err
 			}
-			printElements(elements)
-			return nil
+			return printElements(rows, annotate(store, annotations))
 		},
 	}
+	selectCommand.Flags().BoolVar(&annotations, "annotations", false, "include annotations")
+	return selectCommand
 }
 
 func allCommand() *cobra.Command {
-	return &cobra.Command{
+	var annotations bool
+	allCommand := &cobra.Command{
 		Use:   "all <forensicstore>",
 		Short: "Retrieve all elements",
 		Args:  cobra.ExactArgs(1), //nolint:gomnd
 		RunE: func(cmd *cobra.Command, args []string) error {
 			storeName := cmd.Flags().Args()[0]
-			store, teardown, err := forensicstore.Open(storeName)
+			store, teardown, err := openStore(storeName)
 			if err != nil {
 				return err
 			}
 			defer teardown()
-			elements, err := store.All()
+			rows, err := store.AllRows()
 			if err != nil {
 				return err
 			}
-			printElements(elements)
-			return nil
+			return printElements(rows, annotate(store, annotations))
 		},
 	}
+	allCommand.Flags().BoolVar(&annotations, "annotations", false, "include annotations")
+	return allCommand
 }
 
 func insertCommand() *cobra.Command {
@@ -105,7 +118,7 @@ func insertCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			jsonData := cmd.Flags().Args()[0]
 			storeName := cmd.Flags().Args()[1]
-			store, teardown, err := forensicstore.Open(storeName)
+			store, teardown, err := openStore(storeName)
 			if err != nil {
 				fmt.Println(err)
 				return err
@@ -123,13 +136,34 @@ func insertCommand() *cobra.Command {
 	}
 }
 
-func printElements(elements []forensicstore.JSONElement) {
+// annotate returns a transform for printElements that adds annotations to
+// the elements if enabled.
+func annotate(store *forensicstore.ForensicStore, enabled bool) func(forensicstore.JSONElement) (forensicstore.JSONElement, error) { // nolint:lll
+	if !enabled {
+		return nil
+	}
+	return store.WithAnnotations
+}
+
+// printElements prints the rows as a JSON array. If transform is not nil, it
+// is applied to every element.
+func printElements(rows *forensicstore.Rows, transform func(forensicstore.JSONElement) (forensicstore.JSONElement, error)) error { // nolint:lll
+	defer rows.Close()
 	fmt.Print("[")
-	for i, element := range elements {
+	for i := 0; rows.Next(); i++ {
 		if i != 0 {
 			fmt.Print(",")
 		}
+		element := rows.Element()
+		if transform != nil {
+			var err error
+			element, err = transform(element)
+			if err != nil {
+				return err
+			}
+		}
 		fmt.Print(string(element))
 	}
 	fmt.Print("]")
+	return rows.Err()
 }
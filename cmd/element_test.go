@@ -208,20 +208,34 @@ func Test_insertCommand(t *testing.T) {
 }
 
 func Test_printElements(t *testing.T) {
+	store, teardown, err := forensicstore.New("file::memory:?mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
 	type args struct {
-		elements []forensicstore.JSONElement
+		query string
 	}
 	tests := []struct {
 		name string
 		args args
 		want string
 	}{
-		{"printElements", args{elements: []forensicstore.JSONElement{[]byte(`"test"`), []byte(`"foo"`)}}, `["test","foo"]`},
+		{"printElements", args{query: `SELECT '"test"' AS json UNION ALL SELECT '"foo"' AS json`}, `["test","foo"]`},
+		{"printElements empty", args{query: `SELECT json FROM elements`}, `[]`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			rows, err := store.QueryRows(tt.args.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
 			output := stdout(func() {
-				printElements(tt.args.elements)
+				if err := printElements(rows, nil); err != nil {
+					t.Error(err)
+				}
 			})
 
 			if string(output) != tt.want {
@@ -281,3 +295,36 @@ func Test_selectCommand(t *testing.T) {
 		})
 	}
 }
+
+func Test_getCommand_annotations(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := forensicstore.Open(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddAnnotation("directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7", "alice", "reviewed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := getCommand()
+	args := []string{"--annotations", "directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7", storePath}
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	output := stdout(func() {
+		if err := cmd.RunE(cmd, cmd.Flags().Args()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if !bytes.Contains(output, []byte(`"author":"alice"`)) || !bytes.Contains(output, []byte(`"note":"reviewed"`)) {
+		t.Errorf("getCommand got = %s, want annotations", output)
+	}
+}
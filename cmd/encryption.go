@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+const (
+	passphraseEnv = "FORENSICSTORE_PASSPHRASE"
+	keyFileEnv    = "FORENSICSTORE_KEY_FILE"
+)
+
+// readSecret returns the content of the key file or the passphrase set in the
+// environment, or nil if neither is set.
+func readSecret() ([]byte, error) {
+	if keyFile := os.Getenv(keyFileEnv); keyFile != "" {
+		return ioutil.ReadFile(keyFile) // #nosec
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+// openStore opens an existing store and unlocks encrypted stores with the
+// secret from the environment.
+func openStore(storeName string) (*forensicstore.ForensicStore, func() error, error) {
+	secret, err := readSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret != nil {
+		return forensicstore.OpenEncrypted(storeName, secret)
+	}
+
+	store, teardown, err := forensicstore.Open(storeName)
+	if errors.Is(err, forensicstore.ErrKeyRequired) {
+		return nil, nil, fmt.Errorf("%w: set %s or %s", err, passphraseEnv, keyFileEnv)
+	}
+	return store, teardown, err
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func Test_createEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forensicstorecmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storePath := filepath.Join(dir, "encrypted.forensicstore")
+
+	cmd := Create()
+	args := []string{"--encrypt", storePath}
+	cmd.Flags().Parse(args) // nolint:errcheck
+	if err := cmd.RunE(cmd, args); err == nil {
+		t.Fatal("Create() expected error without secret")
+	}
+
+	t.Setenv(passphraseEnv, "secret")
+	if err := cmd.RunE(cmd, args); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = Verify()
+	cmd.Flags().Parse([]string{storePath}) // nolint:errcheck
+	if err := cmd.RunE(cmd, []string{storePath}); err != nil {
+		t.Error(err)
+	}
+
+	t.Setenv(passphraseEnv, "")
+	if _, _, err := openStore(storePath); !errors.Is(err, forensicstore.ErrKeyRequired) {
+		t.Errorf("openStore() error = %v, want %v", err, forensicstore.ErrKeyRequired)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+// Extract is the forensicstore extract commandline subcommand.
+func Extract() *cobra.Command {
+	var elementType, artifact string
+	var labels []string
+	var plaintext bool
+	extractCommand := &cobra.Command{
+		Use:   "extract <forensicstore> <output forensicstore>",
+		Short: "Extract matching elements and their files into a new forensicstore",
+		Long: "Extract matching elements and their files into a new forensicstore. " +
+			"The output of an encrypted forensicstore is encrypted with the same secret, " +
+			"unless --plaintext is set.",
+		Args: cobra.ExactArgs(2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			args = cmd.Flags().Args()
+			if elementType == "" && artifact == "" && len(labels) == 0 {
+				return errors.New("requires at least one of --type, --artifact or --label")
+			}
+
+			store, teardown, err := openStore(args[0])
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			query := store.Find()
+			if elementType != "" {
+				query = query.Type(elementType)
+			}
+			if artifact != "" {
+				query = query.Artifact(artifact)
+			}
+			for _, label := range labels {
+				query = query.Label(label)
+			}
+			switch {
+			case !store.Encrypted():
+				return query.Extract(args[1])
+			case plaintext:
+				return query.ExtractDecrypted(args[1])
+			}
+			secret, err := readSecret()
+			if err != nil {
+				return err
+			}
+			return query.ExtractEncrypted(args[1], secret)
+		},
+	}
+	extractCommand.Flags().StringVar(&elementType, "type", "", "only extract elements of this type")
+	extractCommand.Flags().StringVar(&artifact, "artifact", "", "only extract elements of this artifact")
+	extractCommand.Flags().StringArrayVar(&labels, "label", nil, "only extract elements with this label")
+	extractCommand.Flags().BoolVar(&plaintext, "plaintext", false, "do not encrypt the output of an encrypted forensicstore")
+	return extractCommand
+}
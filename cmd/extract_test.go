@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func Test_extractCommand(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{"type", []string{"--type", "process"}, 2, false},
+		{"artifact", []string{"--type", "process", "--artifact", "WMILogicalDisks"}, 1, false},
+		{"no filter", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outPath := filepath.Join(dir, tt.name+".forensicstore")
+			args := append(tt.args, storePath, outPath)
+			cmd := Extract()
+			cmd.Flags().Parse(args) // nolint:errcheck
+			err := cmd.RunE(cmd, cmd.Flags().Args())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			store, teardown, err := forensicstore.Open(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+			elements, err := store.All()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(elements) != tt.want {
+				t.Errorf("Extract() got %d elements, want %d", len(elements), tt.want)
+			}
+		})
+	}
+}
+
+func Test_extractCommandEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forensicstorecmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storePath := filepath.Join(dir, "source.forensicstore")
+
+	store, teardown, err := forensicstore.NewEncrypted(storePath, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Insert(forensicstore.JSONElement(`{"id": "file--0c3a6b2e-7a8f-4a4e-9d2b-1c5e8f9a0b1d", "type": "file", "name": "foo.txt"}`)); err != nil { // nolint:lll
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(passphraseEnv, "secret")
+
+	tests := []struct {
+		name          string
+		args          []string
+		wantEncrypted bool
+	}{
+		{"encrypted", []string{"--type", "file"}, true},
+		{"plaintext", []string{"--type", "file", "--plaintext"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outPath := filepath.Join(dir, tt.name+".forensicstore")
+			args := append(tt.args, storePath, outPath)
+			cmd := Extract()
+			cmd.Flags().Parse(args) // nolint:errcheck
+			if err := cmd.RunE(cmd, cmd.Flags().Args()); err != nil {
+				t.Fatal(err)
+			}
+
+			_, teardown, err := forensicstore.Open(outPath)
+			if err == nil {
+				defer teardown()
+			}
+			if encrypted := errors.Is(err, forensicstore.ErrKeyRequired); encrypted != tt.wantEncrypted {
+				t.Errorf("Extract() encrypted = %v, want %v (%v)", encrypted, tt.wantEncrypted, err)
+			}
+		})
+	}
+}
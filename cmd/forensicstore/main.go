@@ -24,6 +24,17 @@
 //     create    Create a forensicstore
 //     import    Import another forensicstore or stix json
 //     element      Edit the forensicstore (insert, get, select, all)
+//     label     Add, remove or list labels of elements
+//     custody   Show or add chain of custody information
+//     info      Show a summary of the forensicstore
+//     upgrade   Upgrade the forensicstore to the current version
+//     merge     Merge forensicstores into one
+//     diff      Show added, removed and changed elements and files
+//     extract   Extract matching elements and their files into a new forensicstore
+//     audit     Print or verify the audit log
+//     verify    Verify the hash chain of the audit log
+//     sign      Sign the forensicstore
+//     verify-signature Verify the signature of the forensicstore
 //     process   Process a workflow.yml
 //     validate  Validate forensicstores
 //
@@ -31,11 +42,44 @@
 //
 // Create a forensicstore
 //     forensicstore create my.forensicstore
+// Create and use a forensicstore with encrypted files
+//     FORENSICSTORE_PASSPHRASE=secret forensicstore create --encrypt my.forensicstore
+//     FORENSICSTORE_KEY_FILE=my.key forensicstore element all my.forensicstore
+// Create a forensicstore that stores files with the same content only once
+//     forensicstore create --dedup my.forensicstore
 // Insert and fetch elements
 //     forensicstore element insert '{"type": "test", "foo": "bar"}' my.forensicstore
 //     forensicstore element get foo--16b02a2b-d1a1-4e79-aad6-2f2c1c286818 my.forensicstore > myelement.json
 //     forensicstore element select foo my.forensicstore > foo_export.json
 //     forensicstore element all my.forensicstore > export.json
+// Label elements
+//     forensicstore label add foo--16b02a2b-d1a1-4e79-aad6-2f2c1c286818 malicious my.forensicstore
+//     forensicstore label ls my.forensicstore
+// Show a summary of a forensicstore
+//     forensicstore info my.forensicstore
+//     forensicstore info --json my.forensicstore
+// Upgrade a forensicstore created by an older version
+//     forensicstore upgrade my.forensicstore
+// Merge the forensicstores of multiple hosts
+//     forensicstore merge all.forensicstore host1.forensicstore host2.forensicstore
+// Compare a baseline with an incident
+//     forensicstore diff baseline.forensicstore incident.forensicstore
+//     forensicstore diff --json --key process=name baseline.forensicstore incident.forensicstore
+// Share a subset of a forensicstore
+//     forensicstore extract --artifact ChromeHistory my.forensicstore chrome.forensicstore
+// Record case metadata and the chain of custody
+//     forensicstore custody set case_number 2020-001 my.forensicstore
+//     forensicstore custody add --from alice --to bob transferred my.forensicstore
+//     forensicstore custody show my.forensicstore
+// Print and verify the audit log
+//     forensicstore audit my.forensicstore
+//     forensicstore audit --verify my.forensicstore
+//     forensicstore verify my.forensicstore
+// Sign and verify forensicstores with Ed25519 keys
+//     openssl genpkey -algorithm ed25519 -out private.pem
+//     openssl pkey -in private.pem -pubout -out public.pem
+//     forensicstore sign private.pem my.forensicstore
+//     forensicstore verify-signature public.pem my.forensicstore
 // Process forensicstore
 //     forensicstore process --workflow myreports.yml my.forensicstore
 //
@@ -57,7 +101,7 @@ func main() {
 		Use:   "forensicstore",
 		Short: "Handle forensicstore files",
 	}
-	rootCmd.AddCommand(cmd.Element(), cmd.Create(), cmd.Validate())
+	rootCmd.AddCommand(cmd.Element(), cmd.Create(), cmd.Validate(), cmd.Label(), cmd.Custody(), cmd.Info(), cmd.Upgrade(), cmd.Merge(), cmd.Diff(), cmd.Extract(), cmd.Audit(), cmd.Verify(), cmd.Sign(), cmd.VerifySignature())
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
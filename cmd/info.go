@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// Info is the forensicstore info commandline subcommand.
+func Info() *cobra.Command {
+	var jsonOutput bool
+	infoCommand := &cobra.Command{
+		Use:   "info <forensicstore>",
+		Short: "Show a summary of the forensicstore",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			store, teardown, err := openStore(cmd.Flags().Args()[0])
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			stats, err := store.Stats()
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(stats, nil)
+			}
+			return printStats(stats)
+		},
+	}
+	infoCommand.Flags().BoolVar(&jsonOutput, "json", false, "print as json")
+	return infoCommand
+}
+
+func printStats(stats *forensicstore.Stats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	fmt.Fprintf(w, "version:\t%d\n", stats.Version)
+	fmt.Fprintf(w, "format:\t%s (application_id 0x%x)\n", stats.Format, stats.ApplicationID)
+	fmt.Fprintf(w, "elements:\t%d\n", stats.Elements)
+
+	var types []string
+	for name := range stats.Types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for _, name := range types {
+		fmt.Fprintf(w, "  %s:\t%d\n", name, stats.Types[name])
+	}
+
+	fmt.Fprintf(w, "files:\t%d\n", stats.Files)
+	fmt.Fprintf(w, "compressed size:\t%d\n", stats.CompressedSize)
+	fmt.Fprintf(w, "uncompressed size:\t%d\n", stats.UncompressedSize)
+	if stats.SavedSize > 0 {
+		fmt.Fprintf(w, "saved by deduplication:\t%d\n", stats.SavedSize)
+	}
+	if stats.FirstInsert != nil && stats.LastInsert != nil {
+		fmt.Fprintf(w, "first insert:\t%s\n", stats.FirstInsert.Format(time.RFC3339))
+		fmt.Fprintf(w, "last insert:\t%s\n", stats.LastInsert.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_infoCommand(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"text", []string{storePath}, []string{"version:                 2\n", "  process:               2\n", "files:                   5\n"}},
+		{"json", []string{"--json", storePath}, []string{`"version":2,`, `"types":{"directory":1,"file":2,"process":2,"windows-registry-key":2}`, `"uncompressed_size":123`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Info()
+			cmd.Flags().Parse(tt.args) // nolint:errcheck
+			output := stdout(func() {
+				if err := cmd.RunE(cmd, cmd.Flags().Args()); err != nil {
+					t.Error(err)
+				}
+			})
+			for _, want := range tt.want {
+				if !strings.Contains(string(output), want) {
+					t.Errorf("Info() got = %s, want %s", output, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// Label is the forensicstore label commandline subcommand.
+func Label() *cobra.Command {
+	labelCommand := &cobra.Command{
+		Use:   "label",
+		Short: "Add, remove or list labels of elements",
+	}
+	labelCommand.AddCommand(labelAddCommand(), labelRemoveCommand(), labelListCommand())
+	return labelCommand
+}
+
+func labelAddCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <id> <label>... <forensicstore>",
+		Short: "Add labels to an element",
+		Args:  cobra.MinimumNArgs(3), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateLabels(cmd.Flags().Args(), (*forensicstore.ForensicStore).AddLabels)
+		},
+	}
+}
+
+func labelRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <id> <label>... <forensicstore>",
+		Short: "Remove labels from an element",
+		Args:  cobra.MinimumNArgs(3), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateLabels(cmd.Flags().Args(), (*forensicstore.ForensicStore).RemoveLabels)
+		},
+	}
+}
+
+func labelListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ls [<label>] <forensicstore>",
+		Short: "List all labels or the elements with a label",
+		Args:  cobra.RangeArgs(1, 2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args = cmd.Flags().Args()
+			storeName := args[len(args)-1]
+			store, teardown, err := openStore(storeName)
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			if len(args) == 2 { //nolint:gomnd
+				rows, err := store.Find().Label(args[0]).Rows()
+				if err != nil {
+					return err
+				}
+				return printElements(rows, nil)
+			}
+
+			labels, err := store.Labels()
+			if err != nil {
+				return err
+			}
+			var names []string
+			for label := range labels {
+				names = append(names, label)
+			}
+			sort.Strings(names)
+			for _, label := range names {
+				fmt.Printf("%s\t%d\n", label, labels[label])
+			}
+			return nil
+		},
+	}
+}
+
+func updateLabels(args []string, update func(*forensicstore.ForensicStore, string, ...string) error) error {
+	id := args[0]
+	labels := args[1 : len(args)-1]
+	storeName := args[len(args)-1]
+	store, teardown, err := openStore(storeName)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+	return update(store, id, labels...)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_labelCommands(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	processID := "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d"
+	fileID := "file--7408798b-6f09-49dd-a3a0-c54fba59c38c"
+
+	tests := []struct {
+		name    string
+		cmd     func() *cobra.Command
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"add", labelAddCommand, []string{processID, "malicious", "reviewed", storePath}, "", false},
+		{"add second", labelAddCommand, []string{fileID, "reviewed", storePath}, "", false},
+		{"add missing", labelAddCommand, []string{"file--00000000-0000-0000-0000-000000000000", "reviewed", storePath}, "", true},
+		{"ls", labelListCommand, []string{storePath}, "malicious\t1\nreviewed\t2\n", false},
+		{"ls label", labelListCommand, []string{"malicious", storePath}, "[" +
+			"{\"artifact\":\"WMILogicalDisks\",\"command_line\":\"powershell \\\"gwmi -Query \\\\\\\"SELECT * FROM Win32_LogicalDisk\\\\\\\"\\\"\",\"created_time\":\"2016-01-20T14:11:25.550Z\"," +
+			"\"cwd\":\"/root/\",\"id\":\"process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d\",\"labels\":[\"malicious\",\"reviewed\"],\"name\":\"powershell\",\"return_code\":0," +
+			"\"stderr_path\":\"WMILogicalDisks/stderr\",\"stdout_path\":\"WMILogicalDisks/stdout\",\"type\":\"process\"}]", false},
+		{"rm", labelRemoveCommand, []string{processID, "reviewed", storePath}, "", false},
+		{"ls after rm", labelListCommand, []string{storePath}, "malicious\t1\nreviewed\t1\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.cmd()
+			cmd.Flags().Parse(tt.args)
+
+			output := stdout(func() {
+				err := cmd.RunE(cmd, tt.args)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%s error = %v, wantErr %v", tt.name, err, tt.wantErr)
+					return
+				}
+			})
+
+			if string(output) != tt.want {
+				t.Errorf("%s got = %v, want %v", tt.name, string(output), tt.want)
+			}
+		})
+	}
+}
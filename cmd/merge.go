@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// Merge is the forensicstore merge commandline subcommand.
+func Merge() *cobra.Command {
+	return &cobra.Command{
+		Use:   "merge <output forensicstore> <forensicstore>...",
+		Short: "Merge forensicstores into one",
+		Long: "Merge forensicstores into one. The output forensicstore is created " +
+			"if it does not exist. It is encrypted with the secret from " + passphraseEnv +
+			" or " + keyFileEnv + " if any input forensicstore is encrypted.",
+		Args: cobra.MinimumNArgs(2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			args = cmd.Flags().Args()
+
+			var srcs []*forensicstore.ForensicStore
+			encrypted := false
+			for _, storeName := range args[1:] {
+				src, srcTeardown, err := openStore(storeName)
+				if err != nil {
+					return err
+				}
+				defer srcTeardown() // nolint:gocritic
+				srcs = append(srcs, src)
+				encrypted = encrypted || src.Encrypted()
+			}
+
+			dst, teardown, err := createMergeStore(args[0], encrypted)
+			if errors.Is(err, forensicstore.ErrStoreExists) {
+				dst, teardown, err = openStore(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			return forensicstore.Merge(dst, srcs...)
+		},
+	}
+}
+
+// createMergeStore creates the output store of merge, which is encrypted if
+// any input store is encrypted.
+func createMergeStore(storeName string, encrypted bool) (*forensicstore.ForensicStore, func() error, error) {
+	if !encrypted {
+		return forensicstore.New(storeName)
+	}
+	secret, err := readSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	return forensicstore.NewEncrypted(storeName, secret)
+}
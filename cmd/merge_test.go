@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func Test_mergeCommand(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+	outPath := filepath.Join(dir, "merged.forensicstore")
+
+	for i := 0; i < 2; i++ {
+		cmd := Merge()
+		args := []string{outPath, storePath}
+		cmd.Flags().Parse(args) // nolint:errcheck
+		if err := cmd.RunE(cmd, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, teardown, err := forensicstore.Open(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	elements, err := store.Find().Where("merge_source.store", forensicstore.Equal, "example1").Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 7 {
+		t.Errorf("Merge() got %d elements, want 7", len(elements))
+	}
+}
+
+func Test_mergeCommandEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forensicstorecmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srcPath := filepath.Join(dir, "encrypted.forensicstore")
+	outPath := filepath.Join(dir, "merged.forensicstore")
+
+	src, teardown, err := forensicstore.NewEncrypted(srcPath, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Insert(forensicstore.JSONElement(`{"id": "file--0c3a6b2e-7a8f-4a4e-9d2b-1c5e8f9a0b1d", "type": "file", "name": "foo.txt"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(passphraseEnv, "secret")
+	cmd := Merge()
+	args := []string{outPath, srcPath}
+	cmd.Flags().Parse(args) // nolint:errcheck
+	if err := cmd.RunE(cmd, args); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := forensicstore.Open(outPath); !errors.Is(err, forensicstore.ErrKeyRequired) {
+		t.Errorf("Merge() output is not encrypted, Open() error = %v", err)
+	}
+}
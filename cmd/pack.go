@@ -181,7 +181,7 @@ basename (e.g. 'example.json')
 
 func setupSource(prefix bool, args []string) (*forensicstore.ForensicStore, afero.Fs, func() error, error) {
 	if prefix {
-		s, teardown, err := forensicstore.Open(args[0])
+		s, teardown, err := openStore(args[0])
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -95,9 +95,14 @@ func TestPack(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
 			if err := os.Chdir(dir); err != nil {
 				t.Fatal(err)
 			}
+			defer os.Chdir(wd) // nolint:errcheck
 
 			packCmd := Pack()
 			if err := packCmd.RunE(packCmd, []string{storePath, filepath.Join(dir, "test.file")}); err != nil {
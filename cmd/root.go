@@ -35,20 +35,48 @@ import (
 
 // Create is the forensicstore create commandline subcommand.
 func Create() *cobra.Command {
-	return &cobra.Command{
+	var encrypt, dedup bool
+	createCommand := &cobra.Command{
 		Use:   "create <forensicstore>",
 		Short: "Create a forensicstore",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 			storeName := cmd.Flags().Args()[0]
-			_, teardown, err := forensicstore.New(storeName)
-			if err != nil {
-				return err
+			var store *forensicstore.ForensicStore
+			var teardown func() error
+			var err error
+			if encrypt {
+				secret, err := readSecret()
+				if err != nil {
+					return err
+				}
+				if secret == nil {
+					return fmt.Errorf("encryption requires %s or %s", passphraseEnv, keyFileEnv)
+				}
+				store, teardown, err = forensicstore.NewEncrypted(storeName, secret)
+				if err != nil {
+					return err
+				}
+			} else {
+				store, teardown, err = forensicstore.New(storeName)
+				if err != nil {
+					return err
+				}
+			}
+			if dedup {
+				if err := store.EnableDeduplication(); err != nil {
+					teardown()
+					return err
+				}
 			}
 			return teardown()
 		},
 	}
+	usage := "encrypt stored files with " + passphraseEnv + " or " + keyFileEnv
+	createCommand.Flags().BoolVar(&encrypt, "encrypt", false, usage)
+	createCommand.Flags().BoolVar(&dedup, "dedup", false, "store files with the same content only once")
+	return createCommand
 }
 
 // JSONElement is the forensicstore element commandline subcommand.
@@ -91,14 +119,14 @@ func Validate() *cobra.Command {
 			}
 
 			storeVersion := binary.BigEndian.Uint32(head[60:64])
-			if storeVersion != forensicstore.Version {
+			if storeVersion < forensicstore.MinVersion || storeVersion > forensicstore.Version {
 				return fmt.Errorf(
-					"unsupported forensicstore version %d, current library uses version %d",
-					storeVersion, forensicstore.Version,
+					"unsupported forensicstore version %d, current library supports versions %d to %d",
+					storeVersion, forensicstore.MinVersion, forensicstore.Version,
 				)
 			}
 
-			store, teardown, err := forensicstore.Open(storeName)
+			store, teardown, err := openStore(storeName)
 			if err != nil {
 				fmt.Println(err)
 				return err
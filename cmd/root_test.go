@@ -0,0 +1,75 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func Test_validateCommand(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name      string
+		storePath string
+		wantErr   bool
+	}{
+		{"version 2", storePath, false},
+		{"version 3", withVersion(t, dir, 3), false},
+		{"version 99", withVersion(t, dir, 99), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Validate()
+			cmd.Flags().Parse([]string{tt.storePath}) // nolint:errcheck
+			stdout(func() {
+				if err := cmd.RunE(cmd, []string{tt.storePath}); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		})
+	}
+}
+
+// withVersion creates a new store with the given user_version.
+func withVersion(t *testing.T, dir string, version int) string {
+	storePath := filepath.Join(dir, fmt.Sprintf("version%d.forensicstore", version))
+	store, teardown, err := forensicstore.New(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.ExecTransient(store.Connection(), fmt.Sprintf("PRAGMA user_version = %d", version), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	return storePath
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+)
+
+// Sign is the forensicstore sign commandline subcommand.
+func Sign() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sign <private key> <forensicstore>",
+		Short: "Sign the forensicstore with an Ed25519 private key (PKCS #8 PEM)",
+		Args:  cobra.ExactArgs(2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			keyFile := cmd.Flags().Args()[0]
+			storeName := cmd.Flags().Args()[1]
+
+			block, err := readPEM(keyFile)
+			if err != nil {
+				return err
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return err
+			}
+			privateKey, ok := key.(ed25519.PrivateKey)
+			if !ok {
+				return fmt.Errorf("%s is not an Ed25519 private key", keyFile)
+			}
+
+			store, teardown, err := openStore(storeName)
+			if err != nil {
+				return err
+			}
+			defer teardown()
+			return store.Sign(privateKey)
+		},
+	}
+}
+
+// VerifySignature is the forensicstore verify-signature commandline
+// subcommand.
+func VerifySignature() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify-signature <public key> <forensicstore>",
+		Short: "Verify the signature of the forensicstore with an Ed25519 public key (PKIX PEM)",
+		Args:  cobra.ExactArgs(2), //nolint:gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			keyFile := cmd.Flags().Args()[0]
+			storeName := cmd.Flags().Args()[1]
+
+			block, err := readPEM(keyFile)
+			if err != nil {
+				return err
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return err
+			}
+			publicKey, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return fmt.Errorf("%s is not an Ed25519 public key", keyFile)
+			}
+
+			store, teardown, err := openStore(storeName)
+			if err != nil {
+				return err
+			}
+			defer teardown()
+			if err := store.VerifySignature(publicKey); err != nil {
+				return err
+			}
+			fmt.Println("signature valid")
+			return nil
+		},
+	}
+}
+
+func readPEM(name string) (*pem.Block, error) {
+	b, err := ioutil.ReadFile(name) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found in " + name)
+	}
+	return block, nil
+}
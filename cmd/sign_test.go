@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func writeKeys(t *testing.T, dir string) (string, string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	err = ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privatePath, publicPath
+}
+
+func Test_signCommands(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	privatePath, publicPath := writeKeys(t, dir)
+
+	tests := []struct {
+		name    string
+		cmd     func() *cobra.Command
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"verify unsigned", VerifySignature, []string{publicPath, storePath}, "", true},
+		{"sign with public key", Sign, []string{publicPath, storePath}, "", true},
+		{"sign", Sign, []string{privatePath, storePath}, "", false},
+		{"verify", VerifySignature, []string{publicPath, storePath}, "signature valid\n", false},
+		{"verify with private key", VerifySignature, []string{privatePath, storePath}, "", true},
+		{"insert", insertCommand, []string{`{"type": "foo"}`, storePath}, "", false},
+		{"verify modified", VerifySignature, []string{publicPath, storePath}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.cmd()
+			cmd.Flags().Parse(tt.args) // nolint:errcheck
+
+			output := stdout(func() {
+				err := cmd.RunE(cmd, tt.args)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%s error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				}
+			})
+
+			if tt.want != "" && string(output) != tt.want {
+				t.Errorf("%s got = %s, want %s", tt.name, output, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+// Upgrade is the forensicstore upgrade commandline subcommand.
+func Upgrade() *cobra.Command {
+	var noBackup bool
+	upgradeCommand := &cobra.Command{
+		Use:   "upgrade <forensicstore>",
+		Short: "Upgrade the forensicstore to the current version",
+		Long: "Upgrade the forensicstore to the current version. A backup of the " +
+			"forensicstore is created as <forensicstore>.v<version>.bak before.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			storeName := cmd.Flags().Args()[0]
+			store, teardown, err := openStore(storeName)
+			if err != nil {
+				return err
+			}
+			defer teardown()
+
+			stats, err := store.Stats()
+			if err != nil {
+				return err
+			}
+			if stats.Version == forensicstore.Version {
+				fmt.Printf("%s is up to date (version %d)\n", storeName, stats.Version)
+				return nil
+			}
+
+			if !noBackup {
+				backup := fmt.Sprintf("%s.v%d.bak", storeName, stats.Version)
+				if err := store.Backup(backup); err != nil {
+					return fmt.Errorf("could not create backup %s: %w", backup, err)
+				}
+			}
+
+			from, err := store.Upgrade()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("upgraded %s from version %d to %d\n", storeName, from, forensicstore.Version)
+			return nil
+		},
+	}
+	upgradeCommand.Flags().BoolVar(&noBackup, "no-backup", false, "do not create a backup")
+	return upgradeCommand
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_upgradeCommand(t *testing.T) {
+	dir, storePath := setup(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"upgrade", "upgraded " + storePath + " from version 2 to 4\n"},
+		{"up to date", storePath + " is up to date (version 4)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Upgrade()
+			cmd.Flags().Parse([]string{storePath}) // nolint:errcheck
+			output := stdout(func() {
+				if err := cmd.RunE(cmd, []string{storePath}); err != nil {
+					t.Error(err)
+				}
+			})
+			if string(output) != tt.want {
+				t.Errorf("Upgrade() got = %s, want %s", output, tt.want)
+			}
+		})
+	}
+
+	if _, err := os.Stat(storePath + ".v2.bak"); err != nil {
+		t.Errorf("Upgrade() backup missing: %s", err)
+	}
+}
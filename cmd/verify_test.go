@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"crawshaw.io/sqlite/sqlitex"
+
+	"github.com/forensicanalysis/forensicstore"
+)
+
+func Test_verifyCommand(t *testing.T) {
+	dir, storePath := setupAudited(t)
+	defer os.RemoveAll(dir)
+
+	cmd := Verify()
+	cmd.Flags().Parse([]string{storePath}) // nolint:errcheck
+	output := stdout(func() {
+		if err := cmd.RunE(cmd, []string{storePath}); err != nil {
+			t.Error(err)
+		}
+	})
+	if len(output) != 0 {
+		t.Errorf("Verify() got = %s, want no output", output)
+	}
+
+	store, teardown, err := forensicstore.Open(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sqlitex.ExecTransient(store.Connection(), "UPDATE elements SET json = json_set(json, '$.name', 'x') "+
+		"WHERE id = 'process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d'", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = Verify()
+	cmd.Flags().Parse([]string{storePath}) // nolint:errcheck
+	output = stdout(func() {
+		if err := cmd.RunE(cmd, []string{storePath}); err == nil {
+			t.Error("Verify() expected verification error")
+		}
+	})
+	if !bytes.Contains(output, []byte("process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d was modified")) {
+		t.Errorf("Verify() got = %s", output)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// The metadata table stores key value pairs about the store, the custody table
+// the chain of custody.
+const (
+	metadataTable = "_metadata"
+	custodyTable  = "_custody"
+)
+
+// Common metadata keys.
+const (
+	MetadataCaseNumber      = "case_number"
+	MetadataExaminer        = "examiner"
+	MetadataAcquisitionHost = "acquisition_host"
+	MetadataToolVersion     = "tool_version"
+)
+
+// Common custody actions.
+const (
+	CustodyAcquired    = "acquired"
+	CustodyTransferred = "transferred"
+	CustodyReceived    = "received"
+	CustodyAnalyzed    = "analyzed"
+	CustodyArchived    = "archived"
+)
+
+// CustodyEvent records an event in the chain of custody of the store, e.g.
+// the hand-over from one examiner to another. Custody events can not be
+// modified or removed once added.
+type CustodyEvent struct {
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// SetMetadata sets a store metadata value like the case number.
+func (store *ForensicStore) SetMetadata(key, value string) error {
+	return store.WithTx(func(tx *ForensicStore) error {
+		err := tx.exec("CREATE TABLE IF NOT EXISTS " + metadataTable + " (key TEXT NOT NULL PRIMARY KEY, value TEXT)")
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.connection.Prepare("INSERT INTO " + metadataTable + " (key, value) VALUES ($key, $value) " +
+			"ON CONFLICT(key) DO UPDATE SET value = excluded.value")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$key", key)
+		stmt.SetText("$value", value)
+		if _, err := stmt.Step(); err != nil {
+			return err
+		}
+		return stmt.Reset()
+	})
+}
+
+// DeleteMetadata removes a store metadata value.
+func (store *ForensicStore) DeleteMetadata(key string) error {
+	return store.WithTx(func(tx *ForensicStore) error {
+		exists, err := tableExists(tx.connection, metadataTable)
+		if err != nil || !exists {
+			return err
+		}
+
+		stmt, err := tx.connection.Prepare("DELETE FROM " + metadataTable + " WHERE key = $key")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$key", key)
+		if _, err := stmt.Step(); err != nil {
+			return err
+		}
+		return stmt.Reset()
+	})
+}
+
+// Metadata returns all store metadata values.
+func (store *ForensicStore) Metadata() (map[string]string, error) {
+	return store.MetadataContext(context.Background())
+}
+
+// MetadataContext is like Metadata, but aborts if ctx is done.
+func (store *ForensicStore) MetadataContext(ctx context.Context) (metadata map[string]string, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	metadata = map[string]string{}
+	exists, err := tableExists(conn, metadataTable)
+	if err != nil || !exists {
+		return metadata, err
+	}
+
+	err = sqlitex.Exec(conn, "SELECT key, value FROM "+metadataTable, func(stmt *sqlite.Stmt) error {
+		metadata[stmt.GetText("key")] = stmt.GetText("value")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// AddCustodyEvent appends an event to the chain of custody and returns its
+// sequence number. The current time is used if the time of the event is not
+// set.
+func (store *ForensicStore) AddCustodyEvent(event CustodyEvent) (seq int64, err error) {
+	if event.Action == "" {
+		return 0, fmt.Errorf("custody event requires an action")
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	err = store.WithTx(func(tx *ForensicStore) error {
+		exists, err := tableExists(tx.connection, custodyTable)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			for _, query := range []string{
+				"CREATE TABLE " + custodyTable + " (" +
+					"seq INTEGER PRIMARY KEY AUTOINCREMENT, time TEXT NOT NULL, action TEXT NOT NULL, " +
+					"\"from\" TEXT, \"to\" TEXT, note TEXT)",
+				"CREATE TRIGGER custody_no_update BEFORE UPDATE ON " + custodyTable +
+					" BEGIN SELECT RAISE(ABORT, 'chain of custody is append-only'); END",
+				"CREATE TRIGGER custody_no_delete BEFORE DELETE ON " + custodyTable +
+					" BEGIN SELECT RAISE(ABORT, 'chain of custody is append-only'); END",
+			} {
+				if err := tx.exec(query); err != nil {
+					return err
+				}
+			}
+		}
+
+		stmt, err := tx.connection.Prepare("INSERT INTO " + custodyTable + " (time, action, \"from\", \"to\", note) " +
+			"VALUES ($time, $action, $from, $to, $note)")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$time", event.Time.UTC().Format(time.RFC3339Nano))
+		stmt.SetText("$action", event.Action)
+		setOptionalText(stmt, "$from", event.From)
+		setOptionalText(stmt, "$to", event.To)
+		setOptionalText(stmt, "$note", event.Note)
+		if _, err := stmt.Step(); err != nil {
+			return err
+		}
+		seq = tx.connection.LastInsertRowID()
+		return stmt.Reset()
+	})
+	return seq, err
+}
+
+// CustodyEvents returns the chain of custody in the order the events were
+// added.
+func (store *ForensicStore) CustodyEvents() ([]CustodyEvent, error) {
+	return store.CustodyEventsContext(context.Background())
+}
+
+// CustodyEventsContext is like CustodyEvents, but aborts if ctx is done.
+func (store *ForensicStore) CustodyEventsContext(ctx context.Context) (events []CustodyEvent, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	events = []CustodyEvent{}
+	exists, err := tableExists(conn, custodyTable)
+	if err != nil || !exists {
+		return events, err
+	}
+
+	query := "SELECT seq, time, action, \"from\", \"to\", note FROM " + custodyTable + " ORDER BY seq"
+	err = sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		t, err := time.Parse(time.RFC3339Nano, stmt.GetText("time"))
+		if err != nil {
+			return err
+		}
+		events = append(events, CustodyEvent{
+			Seq:    stmt.GetInt64("seq"),
+			Time:   t,
+			Action: stmt.GetText("action"),
+			From:   stmt.GetText("from"),
+			To:     stmt.GetText("to"),
+			Note:   stmt.GetText("note"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// custodyFlaws checks that the chain of custody is in chronological order and
+// that every transfer names both parties.
+func (store *ForensicStore) custodyFlaws(ctx context.Context) ([]string, error) {
+	events, err := store.CustodyEventsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var flaws []string
+	for i, event := range events {
+		if i > 0 && event.Time.Before(events[i-1].Time) {
+			flaws = append(flaws, fmt.Sprintf("custody event %d is earlier than custody event %d", event.Seq, events[i-1].Seq))
+		}
+		if event.Action == CustodyTransferred && (event.From == "" || event.To == "") {
+			flaws = append(flaws, fmt.Sprintf("custody event %d is a transfer without from and to", event.Seq))
+		}
+	}
+	return flaws, nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForensicStore_Metadata(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	metadata, err := store.Metadata()
+	assert.NoError(t, err)
+	assert.Empty(t, metadata)
+	assert.NoError(t, store.DeleteMetadata(MetadataCaseNumber))
+
+	assert.NoError(t, store.SetMetadata(MetadataCaseNumber, "2020-001"))
+	assert.NoError(t, store.SetMetadata(MetadataExaminer, "alice"))
+	assert.NoError(t, store.SetMetadata(MetadataExaminer, "bob"))
+
+	metadata, err = store.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{MetadataCaseNumber: "2020-001", MetadataExaminer: "bob"}, metadata)
+
+	assert.NoError(t, store.DeleteMetadata(MetadataExaminer))
+	metadata, err = store.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{MetadataCaseNumber: "2020-001"}, metadata)
+
+	// metadata is not an element type
+	elements, err := store.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 7)
+}
+
+func TestForensicStore_CustodyEvents(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	events, err := store.CustodyEvents()
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	acquired := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	first, err := store.AddCustodyEvent(CustodyEvent{Time: acquired, Action: CustodyAcquired, To: "alice"})
+	assert.NoError(t, err)
+	second, err := store.AddCustodyEvent(CustodyEvent{Action: CustodyTransferred, From: "alice", To: "bob", Note: "by courier"})
+	assert.NoError(t, err)
+	_, err = store.AddCustodyEvent(CustodyEvent{})
+	assert.Error(t, err)
+
+	events, err = store.CustodyEvents()
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, CustodyEvent{Seq: first, Time: acquired, Action: CustodyAcquired, To: "alice"}, events[0])
+		assert.Equal(t, second, events[1].Seq)
+		assert.Equal(t, "by courier", events[1].Note)
+		assert.False(t, events[1].Time.IsZero())
+	}
+
+	// the chain of custody is append-only
+	assert.Error(t, store.exec("DELETE FROM custody"))
+	assert.Error(t, store.exec("UPDATE custody SET action = 'x'"))
+
+	flaws, err := store.custodyFlaws(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
+
+func TestForensicStore_custodyFlaws(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	_, err := store.AddCustodyEvent(CustodyEvent{Action: CustodyReceived})
+	assert.NoError(t, err)
+	_, err = store.AddCustodyEvent(CustodyEvent{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Action: CustodyTransferred, To: "bob"})
+	assert.NoError(t, err)
+
+	flaws, err := store.Validate()
+	assert.NoError(t, err)
+	assert.Contains(t, flaws, "custody event 2 is earlier than custody event 1")
+	assert.Contains(t, flaws, "custody event 2 is a transfer without from and to")
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"crawshaw.io/sqlite"
+)
+
+// DefaultDiffKeys are the fields used to match elements of common types
+// across stores, as their ids differ between collections.
+var DefaultDiffKeys = map[string]string{
+	"file":                 "origin.path",
+	"directory":            "path",
+	"windows-registry-key": "key",
+}
+
+// DiffOptions configure how elements of two stores are matched.
+type DiffOptions struct {
+	// Keys maps element types to the field that identifies an element, e.g.
+	// "key" for registry keys. Elements of other types or without this
+	// field are matched by id.
+	Keys map[string]string
+}
+
+// ElementChange is an element that was added, removed or changed.
+type ElementChange struct {
+	Type   string      `json:"type"`
+	Key    string      `json:"key"`
+	Old    JSONElement `json:"old,omitempty"`
+	New    JSONElement `json:"new,omitempty"`
+	Fields []string    `json:"fields,omitempty"`
+}
+
+// FileChange is a file that was added, removed or changed.
+type FileChange struct {
+	Path    string `json:"path"`
+	OldHash string `json:"old_hash,omitempty"`
+	NewHash string `json:"new_hash,omitempty"`
+}
+
+// StoreDiff lists the differences between two stores.
+type StoreDiff struct {
+	AddedElements   []ElementChange `json:"added_elements"`
+	RemovedElements []ElementChange `json:"removed_elements"`
+	ChangedElements []ElementChange `json:"changed_elements"`
+	AddedFiles      []FileChange    `json:"added_files"`
+	RemovedFiles    []FileChange    `json:"removed_files"`
+	ChangedFiles    []FileChange    `json:"changed_files"`
+}
+
+// Empty returns whether the stores do not differ.
+func (d *StoreDiff) Empty() bool {
+	return len(d.AddedElements) == 0 && len(d.RemovedElements) == 0 && len(d.ChangedElements) == 0 &&
+		len(d.AddedFiles) == 0 && len(d.RemovedFiles) == 0 && len(d.ChangedFiles) == 0
+}
+
+// Diff compares store a with store b. Elements are matched by the keys in
+// options or by DefaultDiffKeys if options is nil. Matched elements are
+// compared field by field, ids are ignored if the elements are matched by a
+// key. Instead of the "*_path" fields the content of the referenced files is
+// compared. Both stores are iterated ordered by type and key, so the changes
+// are ordered the same way and the stores are never loaded into memory.
+func Diff(a, b *ForensicStore, options *DiffOptions) (*StoreDiff, error) {
+	return DiffContext(context.Background(), a, b, options)
+}
+
+// DiffContext is like Diff, but aborts if ctx is done.
+func DiffContext(ctx context.Context, a, b *ForensicStore, options *DiffOptions) (diff *StoreDiff, err error) {
+	keys := DefaultDiffKeys
+	if options != nil && options.Keys != nil {
+		keys = options.Keys
+	}
+
+	diff = &StoreDiff{
+		AddedElements: []ElementChange{}, RemovedElements: []ElementChange{}, ChangedElements: []ElementChange{},
+		AddedFiles: []FileChange{}, RemovedFiles: []FileChange{}, ChangedFiles: []FileChange{},
+	}
+
+	oldRows, err := keyedRows(ctx, a, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer oldRows.Close()
+	newRows, err := keyedRows(ctx, b, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer newRows.Close()
+
+	old, hasOld, err := nextKeyed(oldRows)
+	if err != nil {
+		return nil, err
+	}
+	current, hasNew, err := nextKeyed(newRows)
+	if err != nil {
+		return nil, err
+	}
+	for hasOld || hasNew {
+		order := 0
+		switch {
+		case !hasNew:
+			order = -1
+		case !hasOld:
+			order = 1
+		default:
+			order = compareKeyed(old, current)
+		}
+
+		if order == 0 {
+			fields, err := changedFields(a, b, old, current)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields) > 0 {
+				diff.ChangedElements = append(diff.ChangedElements, ElementChange{
+					Type: old.typ, Key: old.key, Old: old.element, New: current.element, Fields: fields,
+				})
+			}
+		}
+		if order < 0 {
+			diff.RemovedElements = append(diff.RemovedElements, ElementChange{Type: old.typ, Key: old.key, Old: old.element})
+		}
+		if order > 0 {
+			diff.AddedElements = append(diff.AddedElements, ElementChange{
+				Type: current.typ, Key: current.key, New: current.element,
+			})
+		}
+
+		if order <= 0 {
+			if old, hasOld, err = nextKeyed(oldRows); err != nil {
+				return nil, err
+			}
+		}
+		if order >= 0 {
+			if current, hasNew, err = nextKeyed(newRows); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := diffFiles(ctx, a, b, diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+type keyedElement struct {
+	typ     string
+	key     string
+	byID    bool
+	element JSONElement
+	fields  map[string]interface{}
+}
+
+// keyedRows returns a cursor over the elements of a store ordered by type,
+// whether they are matched by id, key and insertion. Elements with the same
+// key are therefore matched in the order they were inserted.
+func keyedRows(ctx context.Context, store *ForensicStore, keys map[string]string) (*Rows, error) {
+	var types []string
+	for elementType := range keys {
+		types = append(types, elementType)
+	}
+	sort.Strings(types)
+
+	fieldKey := "NULL"
+	if len(types) > 0 {
+		fieldKey = "CASE json_extract(json, '$.type')"
+		for i := range types {
+			fieldKey += fmt.Sprintf(" WHEN $type%d THEN json_extract(json, $field%d)", i, i)
+		}
+		fieldKey += " END"
+	}
+
+	query := "SELECT json, type, field_key IS NULL AS by_id, COALESCE(field_key, id) AS element_key FROM (" +
+		"SELECT id, json, rowid AS seq, CAST(COALESCE(json_extract(json, '$.type'), '') AS TEXT) AS type, " +
+		"CAST(" + fieldKey + " AS TEXT) AS field_key FROM elements" +
+		") ORDER BY type, by_id, element_key, seq"
+	return store.queryRows(ctx, query, func(stmt *sqlite.Stmt) {
+		for i, elementType := range types {
+			stmt.SetText(fmt.Sprintf("$type%d", i), elementType)
+			stmt.SetText(fmt.Sprintf("$field%d", i), "$."+keys[elementType])
+		}
+	})
+}
+
+// nextKeyed returns the next element of rows created by keyedRows.
+func nextKeyed(rows *Rows) (keyedElement, bool, error) {
+	if !rows.Next() {
+		return keyedElement{}, false, rows.Err()
+	}
+	e := keyedElement{
+		typ:     rows.stmt.GetText("type"),
+		key:     rows.stmt.GetText("element_key"),
+		byID:    rows.stmt.GetInt64("by_id") != 0,
+		element: rows.Element(),
+	}
+	if err := json.Unmarshal(e.element, &e.fields); err != nil {
+		return keyedElement{}, false, err
+	}
+	return e, true, nil
+}
+
+// compareKeyed compares two elements in the order of keyedRows.
+func compareKeyed(a, b keyedElement) int {
+	if order := strings.Compare(a.typ, b.typ); order != 0 {
+		return order
+	}
+	if a.byID != b.byID {
+		if a.byID {
+			return 1
+		}
+		return -1
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+// changedFields returns the sorted names of the top-level fields that differ.
+func changedFields(a, b *ForensicStore, old, current keyedElement) ([]string, error) {
+	names := map[string]bool{}
+	for field := range old.fields {
+		names[field] = true
+	}
+	for field := range current.fields {
+		names[field] = true
+	}
+
+	var fields []string
+	for field := range names {
+		if field == "id" && !old.byID {
+			continue
+		}
+		oldValue, newValue := old.fields[field], current.fields[field]
+		if strings.HasSuffix(field, "_path") {
+			equal, err := equalFiles(a, b, oldValue, newValue)
+			if err != nil {
+				return nil, err
+			}
+			if !equal {
+				fields = append(fields, field)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(oldValue, newValue) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// equalFiles compares the content of the files referenced by two "*_path"
+// fields.
+func equalFiles(a, b *ForensicStore, oldPath, newPath interface{}) (bool, error) {
+	oldString, oldOK := oldPath.(string)
+	newString, newOK := newPath.(string)
+	if !oldOK || !newOK {
+		return reflect.DeepEqual(oldPath, newPath), nil
+	}
+	oldHash, err := hashFile(a.Fs, oldString)
+	if err != nil {
+		return false, err
+	}
+	newHash, err := hashFile(b.Fs, newString)
+	if err != nil {
+		return false, err
+	}
+	return oldHash == newHash, nil
+}
+
+// diffFiles adds the files that differ by path and content to diff. Files are
+// hashed while both sorted file lists are iterated.
+func diffFiles(ctx context.Context, a, b *ForensicStore, diff *StoreDiff) error {
+	oldFiles, err := a.files(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(oldFiles)
+	newFiles, err := b.files(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(newFiles)
+
+	for len(oldFiles) > 0 || len(newFiles) > 0 {
+		order := 0
+		switch {
+		case len(newFiles) == 0:
+			order = -1
+		case len(oldFiles) == 0:
+			order = 1
+		default:
+			order = strings.Compare(oldFiles[0], newFiles[0])
+		}
+
+		change := FileChange{}
+		if order <= 0 {
+			change.Path = oldFiles[0]
+			if change.OldHash, err = hashFile(a.Fs, oldFiles[0]); err != nil {
+				return err
+			}
+			oldFiles = oldFiles[1:]
+		}
+		if order >= 0 {
+			change.Path = newFiles[0]
+			if change.NewHash, err = hashFile(b.Fs, newFiles[0]); err != nil {
+				return err
+			}
+			newFiles = newFiles[1:]
+		}
+
+		switch {
+		case order < 0:
+			diff.RemovedFiles = append(diff.RemovedFiles, change)
+		case order > 0:
+			diff.AddedFiles = append(diff.AddedFiles, change)
+		case change.OldHash != change.NewHash:
+			diff.ChangedFiles = append(diff.ChangedFiles, change)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestDiff(t *testing.T) {
+	a, teardownA := setup(t)
+	defer teardownA()
+	b, teardownB := setup(t)
+	defer teardownB()
+
+	diff, err := Diff(a, b, nil)
+	assert.NoError(t, err)
+	assert.True(t, diff.Empty())
+
+	runKey := "windows-registry-key--286a78b9-e8e1-4d89-9a3b-6001c817ea64"
+	directory := "directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7"
+
+	assert.NoError(t, b.Patch(ProcessElementId, JSONElement(`{"name": "iptables-legacy"}`)))
+	assert.NoError(t, b.Delete(directory, false))
+	// the run key is collected again with a new id
+	runKeyElement, err := b.Get(runKey)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Delete(runKey, false))
+	runKeyElement = JSONElement(strings.Replace(string(runKeyElement), runKey, "windows-registry-key--00000000-0000-4000-8000-000000000001", 1))
+	_, err = b.Insert(runKeyElement)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Fs.Remove("/WindowsAMCacheHveFile/Amcache.hve"))
+	assert.NoError(t, afero.WriteFile(b.Fs, "/WindowsAMCacheHveFile/Amcache.hve", []byte("B"), 0644))
+	assert.NoError(t, afero.WriteFile(b.Fs, "/new.txt", []byte("new"), 0644))
+	newProcess, err := b.Insert(jsons(element{"id": "process--00000000-0000-4000-8000-000000000002", "type": "process", "name": "bash"}))
+	assert.NoError(t, err)
+
+	diff, err = Diff(a, b, nil)
+	assert.NoError(t, err)
+	assert.False(t, diff.Empty())
+
+	if assert.Len(t, diff.AddedElements, 1) {
+		assert.Equal(t, newProcess, diff.AddedElements[0].Key)
+	}
+	if assert.Len(t, diff.RemovedElements, 1) {
+		assert.Equal(t, "directory", diff.RemovedElements[0].Type)
+		assert.Equal(t, "C:\\Program Files", diff.RemovedElements[0].Key)
+	}
+	changed := map[string][]string{}
+	for _, change := range diff.ChangedElements {
+		changed[change.Key] = change.Fields
+	}
+	assert.Equal(t, map[string][]string{
+		ProcessElementId: {"name"},
+		"C:\\Windows\\appcompat\\Programs\\Amcache.hve": {"export_path"},
+		"C:\\Users\\bob\\Downloads\\foo.doc":            {"export_path"},
+	}, changed)
+
+	assert.Equal(t, []FileChange{{Path: "/new.txt", NewHash: hashBytes([]byte("new"))}}, diff.AddedFiles)
+	assert.Empty(t, diff.RemovedFiles)
+	if assert.Len(t, diff.ChangedFiles, 1) {
+		assert.Equal(t, "/WindowsAMCacheHveFile/Amcache.hve", diff.ChangedFiles[0].Path)
+	}
+
+	// matched by id, the run key is removed and added
+	diff, err = Diff(a, b, &DiffOptions{Keys: map[string]string{}})
+	assert.NoError(t, err)
+	assert.Len(t, diff.AddedElements, 2)
+	assert.Len(t, diff.RemovedElements, 2)
+}
+
+func TestDiff_duplicateKeys(t *testing.T) {
+	a, teardownA := setup(t)
+	defer teardownA()
+	b, teardownB := setup(t)
+	defer teardownB()
+
+	// the same path is collected twice, elements are matched in insert order
+	for i, store := range []*ForensicStore{a, b} {
+		for j, name := range []string{"first", "second"} {
+			_, err := store.Insert(jsons(element{
+				"id":   fmt.Sprintf("directory--00000000-0000-4000-8000-00000000000%d", 2*i+j),
+				"type": "directory", "path": "C:\\Temp", "name": name,
+			}))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, b.Delete(amcacheID, false))
+
+	diff, err := Diff(a, b, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, diff.AddedElements)
+	assert.Empty(t, diff.ChangedElements)
+	if assert.Len(t, diff.RemovedElements, 1) {
+		assert.Equal(t, "C:\\Windows\\appcompat\\Programs\\Amcache.hve", diff.RemovedElements[0].Key)
+	}
+	assert.Empty(t, diff.RemovedFiles)
+
+	_, err = b.Insert(jsons(element{
+		"id": "directory--00000000-0000-4000-8000-000000000004", "type": "directory", "path": "C:\\Temp", "name": "third",
+	}))
+	assert.NoError(t, err)
+	_, err = b.Insert(jsons(element{
+		"id": "directory--00000000-0000-4000-8000-000000000005", "type": "directory", "path": "C:\\", "name": "root",
+	}))
+	assert.NoError(t, err)
+
+	diff, err = Diff(a, b, nil)
+	assert.NoError(t, err)
+	var added []string
+	for _, change := range diff.AddedElements {
+		added = append(added, gjson.GetBytes(change.New, "name").String())
+	}
+	// changes are ordered by type and key
+	assert.Equal(t, []string{"root", "third"}, added)
+}
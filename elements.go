@@ -23,6 +23,7 @@ package forensicstore
 
 import (
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -155,3 +156,27 @@ func (i *Process) AddError(err string) *Process {
 	i.Errors = append(i.Errors, err)
 	return i
 }
+
+// Relationship implements a STIX 2.1 Relationship Object.
+type Relationship struct {
+	ID               string `json:"id"`
+	Type             string `json:"type"`
+	SpecVersion      string `json:"spec_version"`
+	Created          string `json:"created"`
+	Modified         string `json:"modified"`
+	RelationshipType string `json:"relationship_type"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+// NewRelationship creates a new STIX 2.1 Relationship Object.
+func NewRelationship() *Relationship {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return &Relationship{
+		ID:          "relationship--" + uuid.New().String(),
+		Type:        "relationship",
+		SpecVersion: "2.1",
+		Created:     now,
+		Modified:    now,
+	}
+}
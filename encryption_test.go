@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/forensicanalysis/forensicstore/sqlitefs"
+)
+
+func TestNewEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := filepath.Join(dir, "test.forensicstore")
+
+	store, teardown, err := NewEncrypted(url, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, w, closer, err := store.StoreFile("/foo.txt")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("evidence"))
+	assert.NoError(t, err)
+	assert.NoError(t, closer())
+	assert.NoError(t, teardown())
+
+	_, _, err = Open(url)
+	assert.True(t, errors.Is(err, ErrKeyRequired), err)
+
+	_, _, err = OpenEncrypted(url, []byte("wrong"))
+	assert.True(t, errors.Is(err, sqlitefs.ErrWrongKey), err)
+
+	store, teardown, err = OpenEncrypted(url, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	b, err := afero.ReadFile(store.Fs, "/foo.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "evidence", string(b))
+
+	flaws, err := store.VerifyIntegrity()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
+
+func TestOpenPoolEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := filepath.Join(dir, "test.forensicstore")
+
+	_, teardown, err := NewEncrypted(url, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, teardown())
+
+	_, _, err = OpenPool(url, 2)
+	assert.True(t, errors.Is(err, ErrKeyRequired), err)
+
+	_, _, err = OpenPoolEncrypted(url, []byte("wrong"), 2)
+	assert.True(t, errors.Is(err, sqlitefs.ErrWrongKey), err)
+
+	store, teardown, err := OpenPoolEncrypted(url, []byte("secret"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	_, w, closer, err := store.StoreFile("/foo.txt")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("evidence"))
+	assert.NoError(t, err)
+	assert.NoError(t, closer())
+
+	b, err := afero.ReadFile(store.Fs, "/foo.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "evidence", string(b))
+}
+
+func TestOpenEncrypted_notEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := filepath.Join(dir, "test.forensicstore")
+
+	_, teardown, err := New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, teardown())
+
+	// files must not be written in plain text to a store that is expected
+	// to be encrypted
+	_, _, err = OpenEncrypted(url, []byte("secret"))
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+	_, _, err = OpenPoolEncrypted(url, []byte("secret"), 2)
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+}
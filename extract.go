@@ -0,0 +1,142 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// ErrExtractDecrypts is returned by Extract if the store is encrypted. Use
+// ExtractEncrypted or ExtractDecrypted instead.
+var ErrExtractDecrypts = errors.New("cannot extract an encrypted store into an unencrypted store")
+
+// Extract creates a new store at url with the matching elements and exactly
+// the files they reference in "*_path" fields, e.g.
+// store.Find().Artifact("ChromeHistory").Extract("chrome.forensicstore").
+// Referenced files that do not exist in the store are skipped. The insert
+// times and the annotations of the elements are kept. The new store
+// is removed if the extraction fails. Extract returns ErrExtractDecrypts for
+// encrypted stores.
+func (q *ElementQuery) Extract(url string) error {
+	if q.store.Encrypted() {
+		return ErrExtractDecrypts
+	}
+	return q.extract(url, nil)
+}
+
+// ExtractEncrypted is like Extract, but encrypts the new store with a key
+// derived from secret.
+func (q *ElementQuery) ExtractEncrypted(url string, secret []byte) error {
+	return q.extract(url, secret)
+}
+
+// ExtractDecrypted is like Extract, but also extracts encrypted stores into
+// an unencrypted store.
+func (q *ElementQuery) ExtractDecrypted(url string) error {
+	return q.extract(url, nil)
+}
+
+func (q *ElementQuery) extract(url string, secret []byte) (err error) {
+	var dst *ForensicStore
+	var teardown func() error
+	if secret != nil {
+		dst, teardown, err = NewEncrypted(url, secret)
+	} else {
+		dst, teardown, err = New(url)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := teardown(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(url)
+		}
+	}()
+
+	query, args, err := q.sql("json, insert_time")
+	if err != nil {
+		return err
+	}
+	return dst.WithTx(func(tx *ForensicStore) error {
+		rows, err := q.store.queryRows(context.Background(), query, bindArgs(args))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		copied := map[string]bool{}
+		for rows.Next() {
+			element := rows.Element()
+			if err := extractFiles(tx, q.store, element, copied); err != nil {
+				return err
+			}
+			id, err := tx.insert(context.Background(), element, rows.stmt.GetText("insert_time"))
+			if err != nil {
+				return err
+			}
+			if err := copyAnnotations(tx, q.store, id, id); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// extractFiles copies the files referenced in the "*_path" fields of an
+// element from src to dst, unless they were copied before or do not exist.
+func extractFiles(dst, src *ForensicStore, element JSONElement, copied map[string]bool) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(element, &fields); err != nil {
+		return err
+	}
+	for field, value := range fields {
+		path, ok := value.(string)
+		if !strings.HasSuffix(field, "_path") || !ok {
+			continue
+		}
+		path = normalizePath(path)
+		if copied[path] {
+			continue
+		}
+		exists, err := afero.Exists(src.Fs, path)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := copyFile(dst, src, path); err != nil {
+			return err
+		}
+		copied[path] = true
+	}
+	return nil
+}
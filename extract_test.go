@@ -0,0 +1,167 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElementQuery_Extract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown := setup(t)
+	defer teardown()
+	annotationID, err := store.AddAnnotation(ProcessElementId, "analyst", "suspicious")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    *ElementQuery
+		wantIDs  []string
+		wantFile []string
+	}{
+		{"process", store.Find().Type("process").Artifact("IPTablesRules"), []string{ProcessElementId},
+			[]string{"/IPTablesRules/stderr", "/IPTablesRules/stdout"}},
+		{"files", store.Find().Type("file"), []string{amcacheID, fooDocID},
+			[]string{"/WindowsAMCacheHveFile/Amcache.hve"}},
+		{"no files", store.Find().Type("directory"), []string{"directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := filepath.Join(dir, tt.name+".forensicstore")
+			assert.NoError(t, tt.query.Extract(url))
+
+			extracted, teardown, err := Open(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			elements, err := extracted.All()
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.wantIDs, ids(elements))
+
+			files, err := extracted.files(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFile, files)
+
+			flaws, err := extracted.Validate()
+			assert.NoError(t, err)
+			assert.Empty(t, flaws)
+
+			for _, id := range tt.wantIDs {
+				assert.Equal(t, insertTime(t, store, id), insertTime(t, extracted, id))
+			}
+		})
+	}
+
+	// annotations of the extracted elements are copied
+	extracted, extractedTeardown, err := Open(filepath.Join(dir, "process.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations, err := extracted.Annotations(ProcessElementId)
+	assert.NoError(t, err)
+	if assert.Len(t, annotations, 1) {
+		assert.Equal(t, annotationID, annotations[0].ID)
+	}
+	assert.NoError(t, extractedTeardown())
+
+	// the target must not exist
+	assert.Error(t, store.Find().Type("file").Extract(filepath.Join(dir, "files.forensicstore")))
+}
+
+func TestElementQuery_ExtractEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := NewEncrypted(filepath.Join(dir, "encrypted.forensicstore"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+	_, err = store.Insert(jsons(element{"id": "file--0c3a6b2e-7a8f-4a4e-9d2b-1c5e8f9a0b1d", "type": "file", "name": "foo.txt"}))
+	assert.NoError(t, err)
+
+	url := filepath.Join(dir, "plain.forensicstore")
+	assert.ErrorIs(t, store.Find().Type("file").Extract(url), ErrExtractDecrypts)
+	_, err = os.Stat(url)
+	assert.True(t, os.IsNotExist(err))
+
+	url = filepath.Join(dir, "extracted.forensicstore")
+	assert.NoError(t, store.Find().Type("file").ExtractEncrypted(url, []byte("secret")))
+	_, _, err = Open(url)
+	assert.ErrorIs(t, err, ErrKeyRequired)
+	extracted, extractedTeardown, err := OpenEncrypted(url, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extractedTeardown()
+	elements, err := extracted.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+
+	url = filepath.Join(dir, "decrypted.forensicstore")
+	assert.NoError(t, store.Find().Type("file").ExtractDecrypted(url))
+	decrypted, decryptedTeardown, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decryptedTeardown()
+	elements, err = decrypted.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+}
+
+func TestElementQuery_Extract_removeOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown := setup(t)
+	defer teardown()
+
+	// the compressed data of the referenced file is corrupt
+	assert.NoError(t, store.exec("UPDATE sqlar SET data = x'00ff00ff', sz = 1000 WHERE name LIKE '%Amcache.hve'"))
+
+	url := filepath.Join(dir, "broken.forensicstore")
+	assert.Error(t, store.Find().Type("file").Extract(url))
+	_, err = os.Stat(url)
+	assert.True(t, os.IsNotExist(err))
+}
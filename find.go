@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"strings"
+)
+
+// ElementQuery builds a parameterized query for elements. It is created by
+// ForensicStore.Find and executed by Elements or Rows. All conditions are
+// combined with AND.
+type ElementQuery struct {
+	store      *ForensicStore
+	conditions Conditions
+	labels     []string
+	orderBy    []string
+	limit      int64
+	offset     int64
+	err        error
+}
+
+// Find starts a new query for elements, e.g.
+// store.Find().Type("file").Where("size", ">", 1024).Limit(100).Elements().
+func (store *ForensicStore) Find() *ElementQuery {
+	return &ElementQuery{store: store, limit: -1}
+}
+
+// Type restricts the query to elements of the given type.
+func (q *ElementQuery) Type(elementType string) *ElementQuery {
+	return q.Where("type", Equal, elementType)
+}
+
+// Artifact restricts the query to elements of the given artifact.
+func (q *ElementQuery) Artifact(artifact string) *ElementQuery {
+	return q.Where("artifact", Equal, artifact)
+}
+
+// Where adds a condition on a field of the elements.
+func (q *ElementQuery) Where(field, operator string, value interface{}) *ElementQuery {
+	q.conditions = append(q.conditions, Condition{Field: field, Operator: operator, Value: value})
+	return q
+}
+
+// Label restricts the query to elements that have the given label.
+func (q *ElementQuery) Label(label string) *ElementQuery {
+	q.labels = append(q.labels, label)
+	return q
+}
+
+// OrderBy sorts the elements ascending by a field.
+func (q *ElementQuery) OrderBy(field string) *ElementQuery {
+	return q.order(field, "ASC")
+}
+
+// OrderByDesc sorts the elements descending by a field.
+func (q *ElementQuery) OrderByDesc(field string) *ElementQuery {
+	return q.order(field, "DESC")
+}
+
+func (q *ElementQuery) order(field, direction string) *ElementQuery {
+	path, err := jsonPath(field)
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.orderBy = append(q.orderBy, "json_extract(json, "+path+") "+direction)
+	return q
+}
+
+// Limit sets the maximal number of returned elements.
+func (q *ElementQuery) Limit(limit int64) *ElementQuery {
+	q.limit = limit
+	return q
+}
+
+// Offset skips the first elements.
+func (q *ElementQuery) Offset(offset int64) *ElementQuery {
+	q.offset = offset
+	return q
+}
+
+// Elements executes the query and returns all matching elements.
+func (q *ElementQuery) Elements() ([]JSONElement, error) {
+	rows, err := q.RowsContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return rows.collect()
+}
+
+// Rows executes the query and returns a cursor over the matching elements.
+func (q *ElementQuery) Rows() (*Rows, error) {
+	return q.RowsContext(context.Background())
+}
+
+// RowsContext is like Rows, but the cursor aborts if ctx is done.
+func (q *ElementQuery) RowsContext(ctx context.Context) (*Rows, error) {
+	query, args, err := q.sql("json")
+	if err != nil {
+		return nil, err
+	}
+	return q.store.queryRows(ctx, query, bindArgs(args))
+}
+
+// sql compiles the query to SQL selecting the given columns.
+func (q *ElementQuery) sql(columns string) (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+
+	var ands []string
+	var args []interface{}
+	for _, condition := range q.conditions {
+		expr, exprArgs, err := condition.sql()
+		if err != nil {
+			return "", nil, err
+		}
+		ands = append(ands, expr)
+		args = append(args, exprArgs...)
+	}
+	for _, label := range q.labels {
+		if q.store.labelsTable {
+			ands = append(ands, "id IN (SELECT element_id FROM "+labelsTable+" WHERE label = ?)")
+		} else {
+			ands = append(ands, "EXISTS (SELECT 1 FROM json_each(elements.json, '$.labels') WHERE value = ?)")
+		}
+		args = append(args, label)
+	}
+
+	query := "SELECT " + columns + " FROM elements"
+	if len(ands) > 0 {
+		query += " WHERE " + strings.Join(ands, " AND ")
+	}
+	if len(q.orderBy) > 0 {
+		query += " ORDER BY " + strings.Join(q.orderBy, ", ")
+	}
+	if q.limit >= 0 || q.offset > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.limit, q.offset)
+	}
+	return query, args, nil
+}
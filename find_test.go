@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestForensicStore_Find(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	_, err := store.Insert(jsons(element{"id": "file--1b7ab9fb-3d4c-4a3e-9a73-1c3c0d0c2c01", "type": "file", "name": "evil.exe", "size": 2048, "labels": []string{"malware", "reviewed"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Insert(jsons(element{"id": "file--1b7ab9fb-3d4c-4a3e-9a73-1c3c0d0c2c02", "type": "file", "name": "good.exe", "size": 4096, "labels": []string{"reviewed"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		query     *ElementQuery
+		wantNames []string
+		wantErr   bool
+	}{
+		{"Type", store.Find().Type("directory"), []string{""}, false},
+		{"Artifact", store.Find().Artifact("WindowsCodePage"), []string{""}, false},
+		{"Where", store.Find().Type("file").Where("size", ">", 1024).OrderBy("size"), []string{"evil.exe", "good.exe"}, false},
+		{"Label", store.Find().Label("malware"), []string{"evil.exe"}, false},
+		{"Labels", store.Find().Label("malware").Label("reviewed"), []string{"evil.exe"}, false},
+		{"OrderBy", store.Find().Type("file").OrderBy("name"), []string{"Amcache.hve", "evil.exe", "foo.doc", "good.exe"}, false},
+		{"OrderByDesc", store.Find().Type("file").OrderByDesc("size").Limit(2), []string{"good.exe", "evil.exe"}, false},
+		{"Offset", store.Find().Type("file").OrderBy("name").Limit(2).Offset(1), []string{"evil.exe", "foo.doc"}, false},
+		{"Quote", store.Find().Where("name", "=", "evil.exe' OR '1'='1"), nil, false},
+		{"Invalid field", store.Find().Where("name'", "=", "x"), nil, true},
+		{"Invalid order", store.Find().OrderBy("name; DROP TABLE elements"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elements, err := tt.query.Elements()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ElementQuery.Elements() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var names []string
+			for _, element := range elements {
+				names = append(names, gjson.GetBytes(element, "name").String())
+			}
+			assert.Equal(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestElementQuery_index(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	query, args, err := store.Find().Type("file").Where("origin.path", "=", "x").sql("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release(&err)
+
+	var plan []string
+	err = sqlitex.Exec(conn, "EXPLAIN QUERY PLAN "+query, func(stmt *sqlite.Stmt) error {
+		plan = append(plan, stmt.ColumnText(3))
+		return nil
+	}, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, strings.Join(plan, "\n"), "INDEX")
+}
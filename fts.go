@@ -0,0 +1,180 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"crawshaw.io/sqlite"
+)
+
+// ftsTable is the FTS5 table of the full-text index. Its shadow tables end
+// with _data, _idx, _content, _docsize and _config.
+const ftsTable = "elements_fts"
+
+// ftsIDsTable maps the rowids of the full-text index to element ids. The
+// implicit rowid of the elements table can change on VACUUM, the explicit
+// fts_id of this table can not.
+const ftsIDsTable = "_elements_fts_ids"
+
+// ftsContent extracts all scalar values of an element as text.
+const ftsContent = "(SELECT group_concat(value, ' ') FROM json_tree(%s.json) WHERE atom IS NOT NULL)"
+
+// FullTextOptions configure FullTextSearch.
+type FullTextOptions struct {
+	// Types restricts the search to elements of the given types.
+	Types []string
+	// HighlightStart and HighlightEnd surround matches in the snippet. They
+	// default to "<b>" and "</b>".
+	HighlightStart string
+	HighlightEnd   string
+	// Limit is the maximal number of results, all results are returned if it
+	// is 0.
+	Limit int
+}
+
+// FullTextResult is a single result of FullTextSearch.
+type FullTextResult struct {
+	Element JSONElement
+	// Snippet is an excerpt of the element values with highlighted matches.
+	Snippet string
+	// Rank is the bm25 score of the element, lower is better.
+	Rank float64
+}
+
+// EnableFullTextSearch creates the full-text index for all elements. The
+// index is maintained by triggers on insert, update and delete. Calling
+// EnableFullTextSearch on a store with an index does nothing.
+func (store *ForensicStore) EnableFullTextSearch() error {
+	enabled, err := store.FullTextSearchEnabled()
+	if err != nil || enabled {
+		return err
+	}
+
+	newContent := fmt.Sprintf(ftsContent, "new")
+	insert := "INSERT INTO " + ftsIDsTable + " (id) VALUES (new.id); " +
+		"INSERT INTO " + ftsTable + " (rowid, id, type, content) " +
+		"VALUES (last_insert_rowid(), new.id, json_extract(new.json, '$.type'), " + newContent + "); "
+	remove := "DELETE FROM " + ftsTable + " WHERE rowid = (SELECT fts_id FROM " + ftsIDsTable + " WHERE id = old.id); " +
+		"DELETE FROM " + ftsIDsTable + " WHERE id = old.id; "
+	statements := []string{
+		"CREATE TABLE " + ftsIDsTable + " (fts_id INTEGER PRIMARY KEY, id TEXT NOT NULL UNIQUE)",
+		"CREATE VIRTUAL TABLE " + ftsTable + " USING fts5(id UNINDEXED, type UNINDEXED, content)",
+		"CREATE TRIGGER elements_fts_insert AFTER INSERT ON elements BEGIN " + insert + "END",
+		"CREATE TRIGGER elements_fts_delete AFTER DELETE ON elements BEGIN " + remove + "END",
+		"CREATE TRIGGER elements_fts_update AFTER UPDATE ON elements BEGIN " + remove + insert + "END",
+		"INSERT INTO " + ftsIDsTable + " (id) SELECT id FROM elements",
+		"INSERT INTO " + ftsTable + " (rowid, id, type, content) " +
+			"SELECT fts_id, elements.id, json_extract(json, '$.type'), " + fmt.Sprintf(ftsContent, "elements") + " " +
+			"FROM elements JOIN " + ftsIDsTable + " ON " + ftsIDsTable + ".id = elements.id",
+	}
+
+	return store.WithTx(func(tx *ForensicStore) error {
+		for _, statement := range statements {
+			if err := tx.exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FullTextSearchEnabled returns whether the store has a full-text index.
+func (store *ForensicStore) FullTextSearchEnabled() (enabled bool, err error) {
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		return false, err
+	}
+	defer release(&err)
+
+	return tableExists(conn, ftsTable)
+}
+
+// FullTextSearch searches the full-text index created by
+// EnableFullTextSearch. The query uses the FTS5 syntax and supports phrases
+// ("foo bar"), prefixes (foo*) and boolean operators (foo AND NOT bar).
+// Results are ordered by relevance.
+func (store *ForensicStore) FullTextSearch(query string, options *FullTextOptions) ([]FullTextResult, error) {
+	return store.FullTextSearchContext(context.Background(), query, options)
+}
+
+// FullTextSearchContext is like FullTextSearch, but aborts if ctx is done.
+func (store *ForensicStore) FullTextSearchContext(ctx context.Context, query string, options *FullTextOptions) (results []FullTextResult, err error) { // nolint:lll
+	if options == nil {
+		options = &FullTextOptions{}
+	}
+	start, end := options.HighlightStart, options.HighlightEnd
+	if start == "" && end == "" {
+		start, end = "<b>", "</b>"
+	}
+
+	args := []interface{}{start, end, query}
+	sql := "SELECT elements.json AS json, snippet(" + ftsTable + ", 2, ?, ?, '...', 16) AS snippet, rank " +
+		"FROM " + ftsTable + " JOIN " + ftsIDsTable + " ON " + ftsIDsTable + ".fts_id = " + ftsTable + ".rowid " +
+		"JOIN elements ON elements.id = " + ftsIDsTable + ".id " +
+		"WHERE " + ftsTable + " MATCH ?"
+	if len(options.Types) > 0 {
+		sql += " AND " + ftsTable + ".type IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(options.Types)), ", ") + ")"
+		for _, t := range options.Types {
+			args = append(args, t)
+		}
+	}
+	sql += " ORDER BY rank"
+	if options.Limit > 0 {
+		sql += " LIMIT ?"
+		args = append(args, options.Limit)
+	}
+
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	stmt, _, err := conn.PrepareTransient(sql)
+	if err != nil {
+		if sqlite.ErrCode(err) == sqlite.SQLITE_ERROR && strings.Contains(err.Error(), "no such table") {
+			return nil, fmt.Errorf("full-text search is not enabled: %w", err)
+		}
+		return nil, err
+	}
+	defer stmt.Finalize() // nolint:errcheck
+	bindArgs(args)(stmt)
+
+	results = []FullTextResult{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			return results, nil
+		}
+		results = append(results, FullTextResult{
+			Element: JSONElement(stmt.GetText("json")),
+			Snippet: stmt.GetText("snippet"),
+			Rank:    stmt.GetFloat("rank"),
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestForensicStore_FullTextSearch(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	_, err := store.FullTextSearch("iptables", nil)
+	assert.Error(t, err)
+
+	enabled, err := store.FullTextSearchEnabled()
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	// existing elements are indexed
+	assert.NoError(t, store.EnableFullTextSearch())
+	assert.NoError(t, store.EnableFullTextSearch())
+
+	// new, updated and deleted elements are indexed
+	id, err := store.Insert(jsons(element{"type": "foo", "name": "quarterly report"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Update(ProcessElementId, jsons(element{"type": "process", "name": "nftables"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Delete("directory--ed070d8c-c8d9-40ab-ae18-3f6b6725b7a7", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		options     *FullTextOptions
+		wantIDs     []string
+		wantSnippet string
+		wantErr     bool
+	}{
+		{"Word", "powershell", nil, []string{"process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d"}, "<b>powershell</b>", false},
+		{"Inserted", "report", nil, []string{id}, "quarterly <b>report</b>", false},
+		{"Updated", "nftables", nil, []string{ProcessElementId}, "<b>nftables</b>", false},
+		{"Updated old value", "iptables", nil, []string{}, "", false},
+		{"Deleted", "Program", nil, []string{}, "", false},
+		{"Phrase", `"quarterly report"`, nil, []string{id}, "", false},
+		{"Prefix", "quart*", nil, []string{id}, "", false},
+		{"Or", "foo OR powershell", nil, []string{"file--7408798b-6f09-49dd-a3a0-c54fba59c38c", "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d", id}, "", false},
+		{"Not", "foo NOT bob", nil, []string{id}, "", false},
+		{"Types", "foo", &FullTextOptions{Types: []string{"file"}}, []string{"file--7408798b-6f09-49dd-a3a0-c54fba59c38c"}, "", false},
+		{"Highlight", "quarterly", &FullTextOptions{HighlightStart: "[", HighlightEnd: "]"}, []string{id}, "[quarterly] report", false},
+		{"Limit", "foo", &FullTextOptions{Limit: 1}, nil, "", false},
+		{"Syntax error", `"foo`, nil, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.FullTextSearch(tt.query, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.FullTextSearch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantIDs == nil {
+				assert.Len(t, results, 1)
+				return
+			}
+			ids := []string{}
+			for _, result := range results {
+				ids = append(ids, gjson.GetBytes(result.Element, "id").String())
+			}
+			assert.ElementsMatch(t, tt.wantIDs, ids)
+			if tt.wantSnippet != "" {
+				assert.Contains(t, results[0].Snippet, tt.wantSnippet)
+			}
+		})
+	}
+}
+
+func TestForensicStore_FullTextSearch_rowids(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	assert.NoError(t, store.EnableFullTextSearch())
+	renumberElements(t, store)
+
+	results, err := store.FullTextSearch("powershell", nil)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d", gjson.GetBytes(results[0].Element, "id").String())
+	}
+
+	// elements are indexed after the rowids changed as well
+	id, err := store.Insert(jsons(element{"type": "foo", "name": "quarterly report"}))
+	assert.NoError(t, err)
+	results, err = store.FullTextSearch("report", nil)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, id, gjson.GetBytes(results[0].Element, "id").String())
+	}
+}
+
+// renumberElements changes the rowids of all elements without firing any
+// trigger, like VACUUM may do.
+func renumberElements(t *testing.T, store *ForensicStore) {
+	var triggers []string
+	query := "SELECT sql FROM sqlite_master WHERE type = 'trigger' AND tbl_name = 'elements'"
+	err := sqlitex.ExecTransient(store.connection, query, func(stmt *sqlite.Stmt) error {
+		triggers = append(triggers, stmt.GetText("sql"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statements := []string{
+		"CREATE TEMP TABLE elements_copy AS SELECT * FROM elements",
+		"DELETE FROM elements",
+		"INSERT INTO elements SELECT * FROM elements_copy ORDER BY id DESC",
+		"DROP TABLE elements_copy",
+	}
+	for _, trigger := range triggers {
+		name := strings.Fields(trigger)[2]
+		statements = append([]string{"DROP TRIGGER " + name}, statements...)
+		statements = append(statements, trigger)
+	}
+	for _, statement := range statements {
+		if err := sqlitex.ExecTransient(store.connection, statement, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
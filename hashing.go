@@ -0,0 +1,127 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"crypto/md5"  // #nosec
+	"crypto/sha1" // #nosec
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ErrFileNotClosed is returned by HashedFile.File if the file is not closed
+// yet.
+var ErrFileNotClosed = errors.New("file is not closed")
+
+// StoreFileOption configures StoreFile.
+type StoreFileOption func(options *storeFileOptions)
+
+type storeFileOptions struct {
+	hashed *HashedFile
+}
+
+// WithHashes records the size and the hashes of the content written to the
+// file returned by StoreFile in hashed, e.g.
+//
+//	var hashed HashedFile
+//	_, file, teardown, err := store.StoreFile("/foo.txt", WithHashes(&hashed))
+//	...
+//	if err := teardown(); err != nil {
+//	    return err
+//	}
+//	element, err := hashed.File()
+//	...
+//	_, err = store.InsertStruct(element)
+func WithHashes(hashed *HashedFile) StoreFileOption {
+	return func(options *storeFileOptions) {
+		options.hashed = hashed
+	}
+}
+
+// HashedFile records the size and the MD5, SHA-1 and SHA-256 hashes of a file
+// written by StoreFile with WithHashes. The SHA-256 hash is shared with the
+// audit log, so the hashes are only available after the file is closed.
+type HashedFile struct {
+	name   string
+	path   string
+	size   int64
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 string
+	closed bool
+}
+
+func newHashedFile(name, path string) HashedFile {
+	return HashedFile{name: name, path: path, md5: md5.New(), sha1: sha1.New()} // #nosec
+}
+
+func (f *HashedFile) write(p []byte) {
+	f.size += int64(len(p))
+	f.md5.Write(p)  // nolint:errcheck
+	f.sha1.Write(p) // nolint:errcheck
+}
+
+func (f *HashedFile) close(sha256 string) {
+	f.sha256 = sha256
+	f.closed = true
+}
+
+// Path returns the path of the file in the store, which can differ from the
+// requested path if a file with this name already exists.
+func (f *HashedFile) Path() string {
+	return f.path
+}
+
+// Size returns the number of bytes written.
+func (f *HashedFile) Size() int64 {
+	return f.size
+}
+
+// Hashes returns the hex encoded hashes of the written content. It returns
+// nil if the file is not closed yet.
+func (f *HashedFile) Hashes() map[string]interface{} {
+	if !f.closed {
+		return nil
+	}
+	return map[string]interface{}{
+		"MD5":     fmt.Sprintf("%x", f.md5.Sum(nil)),
+		"SHA-1":   fmt.Sprintf("%x", f.sha1.Sum(nil)),
+		"SHA-256": f.sha256,
+	}
+}
+
+// File creates a File element for the written file with the name, export path,
+// size and hashes filled in. It returns ErrFileNotClosed if the file is not
+// closed yet.
+func (f *HashedFile) File() (*File, error) {
+	if !f.closed {
+		return nil, ErrFileNotClosed
+	}
+	file := NewFile()
+	file.Name = f.name
+	file.ExportPath = strings.TrimPrefix(f.path, "/")
+	file.Size = float64(f.size)
+	file.Hashes = f.Hashes()
+	return file, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForensicStore_StoreFile_WithHashes(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	for _, storePath := range []string{"/hashed/foo.txt", "/hashed/foo_0.txt"} {
+		var f HashedFile
+		_, w, fileTeardown, err := store.StoreFile("/hashed/foo.txt", WithHashes(&f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte("hello"))
+		assert.NoError(t, err)
+		// the hashes are not available before the file is closed
+		assert.Nil(t, f.Hashes())
+		_, err = f.File()
+		assert.ErrorIs(t, err, ErrFileNotClosed)
+		assert.NoError(t, fileTeardown())
+
+		assert.Equal(t, storePath, f.Path())
+		assert.Equal(t, int64(5), f.Size())
+		assert.Equal(t, map[string]interface{}{
+			"MD5":     "5d41402abc4b2a76b9719d911017c592",
+			"SHA-1":   "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+			"SHA-256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		}, f.Hashes())
+
+		// the audit log records the same SHA-256 hash
+		entries, err := store.AuditLog()
+		assert.NoError(t, err)
+		if assert.NotEmpty(t, entries) {
+			last := entries[len(entries)-1]
+			assert.Equal(t, AuditStoreFile, last.Operation)
+			assert.Equal(t, f.Hashes()["SHA-256"], last.AfterHash)
+		}
+
+		file, err := f.File()
+		assert.NoError(t, err)
+		assert.Equal(t, "foo.txt", file.Name)
+		assert.Equal(t, storePath[1:], file.ExportPath)
+		assert.Equal(t, float64(5), file.Size)
+		_, err = store.InsertStruct(file)
+		assert.NoError(t, err)
+
+		b, err := afero.ReadFile(store.Fs, storePath)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	flaws, err := store.Validate()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
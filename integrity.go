@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// The audit log is a linear hash chain: the chain hash of every entry is the
+// SHA-256 of the previous chain hash and the entry itself. Altering, removing
+// or reordering entries breaks the chain, so the last chain hash (ChainHead)
+// covers all changes of elements and files.
+
+// ChainHead returns the chain hash of the last audit log entry. It is empty
+// if the store has no audit log.
+func (store *ForensicStore) ChainHead() (head string, err error) {
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		return "", err
+	}
+	defer release(&err)
+
+	exists, err := tableExists(conn, auditTable)
+	if err != nil || !exists {
+		return "", err
+	}
+	return chainHead(conn)
+}
+
+// VerifyIntegrity checks the hash chain of the audit log and compares all
+// elements and files with their last recorded hash. It returns a flaw for
+// every altered, missing or unrecorded element or file.
+func (store *ForensicStore) VerifyIntegrity() (flaws []string, err error) {
+	return store.VerifyIntegrityContext(context.Background())
+}
+
+// VerifyIntegrityContext is like VerifyIntegrity, but aborts if ctx is done.
+func (store *ForensicStore) VerifyIntegrityContext(ctx context.Context) (flaws []string, err error) {
+	entries, err := store.AuditLogContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	flaws = []string{}
+	head := ""
+	recordedFiles := map[string]bool{}
+	for _, entry := range entries {
+		chain, err := entry.chain(head)
+		if err != nil {
+			return nil, err
+		}
+		if chain != entry.Chain {
+			flaws = append(flaws, fmt.Sprintf("hash chain broken at audit entry %d", entry.Seq))
+		}
+		head = entry.Chain
+		if entry.Path != "" {
+			recordedFiles[normalizePath(entry.Path)] = true
+		}
+	}
+
+	stateFlaws, err := store.VerifyAuditContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flaws = append(flaws, stateFlaws...)
+
+	files, err := store.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		if !recordedFiles[path] {
+			flaws = append(flaws, fmt.Sprintf("file %s is not in the audit log", path))
+		}
+	}
+	return flaws, nil
+}
+
+func (store *ForensicStore) files(ctx context.Context) (files []string, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+	return store.storedFiles(conn)
+}
+
+// chain returns the chain hash of the entry following head.
+func (entry AuditEntry) chain(head string) (string, error) {
+	b, err := json.Marshal(struct {
+		Previous   string `json:"previous"`
+		Time       string `json:"time"`
+		User       string `json:"user"`
+		Operation  string `json:"operation"`
+		ElementID  string `json:"element_id"`
+		Path       string `json:"path"`
+		BeforeHash string `json:"before_hash"`
+		AfterHash  string `json:"after_hash"`
+	}{
+		head, entry.Time.UTC().Format(time.RFC3339Nano), entry.User, entry.Operation,
+		entry.ElementID, entry.Path, entry.BeforeHash, entry.AfterHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+func chainHead(conn *sqlite.Conn) (string, error) {
+	stmt, err := conn.Prepare("SELECT chain FROM " + auditTable + " ORDER BY seq DESC LIMIT 1")
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Reset() // nolint:errcheck
+	hasRow, err := stmt.Step()
+	if err != nil || !hasRow {
+		return "", err
+	}
+	return stmt.GetText("chain"), nil
+}
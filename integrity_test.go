@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupIntegrity(t *testing.T) (*ForensicStore, string, func() error) {
+	store, teardown, err := New("file::memory:?mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.Insert(jsons(element{"type": "foo", "name": "bar"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, w, teardownFile, err := store.StoreFile("/foo/bar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := teardownFile(); err != nil {
+		t.Fatal(err)
+	}
+	return store, id, teardown
+}
+
+func TestForensicStore_ChainHead(t *testing.T) {
+	store, _, teardown := setupIntegrity(t)
+	defer teardown()
+
+	head, err := store.ChainHead()
+	assert.NoError(t, err)
+	assert.Len(t, head, 64)
+
+	_, err = store.Insert(jsons(element{"type": "foo", "name": "baz"}))
+	assert.NoError(t, err)
+
+	newHead, err := store.ChainHead()
+	assert.NoError(t, err)
+	assert.NotEqual(t, head, newHead)
+
+	entries, err := store.AuditLog()
+	assert.NoError(t, err)
+	assert.Equal(t, newHead, entries[len(entries)-1].Chain)
+}
+
+func TestForensicStore_VerifyIntegrity(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(store *ForensicStore, id string) error
+		want   []string
+	}{
+		{"unchanged", func(store *ForensicStore, id string) error { return nil }, []string{}},
+		{"element modified", func(store *ForensicStore, id string) error {
+			return store.exec("UPDATE elements SET json = json_set(json, '$.name', 'x') WHERE id = '" + id + "'")
+		}, []string{"element ID was modified outside of the audit log"}},
+		{"file injected", func(store *ForensicStore, id string) error {
+			f, err := store.Fs.Create("/evil.txt")
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		}, []string{"file /evil.txt is not in the audit log"}},
+		{"file removed", func(store *ForensicStore, id string) error {
+			return store.exec("DELETE FROM sqlar WHERE name LIKE '%bar.txt'")
+		}, []string{"file /foo/bar.txt is missing"}},
+		{"audit entry modified", func(store *ForensicStore, id string) error {
+			if err := store.exec("DROP TRIGGER audit_no_update"); err != nil {
+				return err
+			}
+			return store.exec("UPDATE _audit SET user = 'mallory' WHERE seq = 1")
+		}, []string{"hash chain broken at audit entry 1"}},
+		{"audit entry removed", func(store *ForensicStore, id string) error {
+			if err := store.exec("DROP TRIGGER audit_no_delete"); err != nil {
+				return err
+			}
+			return store.exec("DELETE FROM _audit WHERE seq = 1")
+		}, []string{"hash chain broken at audit entry 2", "element ID is not in the audit log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, id, teardown := setupIntegrity(t)
+			defer teardown()
+
+			if err := tt.tamper(store, id); err != nil {
+				t.Fatal(err)
+			}
+
+			flaws, err := store.VerifyIntegrity()
+			assert.NoError(t, err)
+			var want []string
+			for _, flaw := range tt.want {
+				want = append(want, strings.Replace(flaw, "ID", id, 1))
+			}
+			assert.ElementsMatch(t, want, flaws)
+		})
+	}
+}
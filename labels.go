@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// labelsTable maps labels to elements, so elements can be selected by label
+// with an index. The table is maintained by triggers on the elements table.
+// It is created by New and Upgrade, stores of version 3 and before that are
+// not upgraded yet are queried without it.
+const labelsTable = "_labels"
+
+// insertLabels and deleteLabels are the trigger bodies of the labels table.
+const (
+	insertLabels = "INSERT OR IGNORE INTO " + labelsTable + " (label, element_id) " +
+		"SELECT value, new.id FROM json_each(new.json, '$.labels') WHERE type = 'text'; "
+	deleteLabels = "DELETE FROM " + labelsTable + " WHERE element_id = old.id; "
+)
+
+// createLabelsTable creates the labels table and its triggers and adds the
+// labels of all existing elements.
+func (store *ForensicStore) createLabelsTable() error {
+	for _, statement := range []string{
+		"CREATE TABLE " + labelsTable + " (label TEXT NOT NULL, element_id TEXT NOT NULL, " +
+			"PRIMARY KEY (label, element_id)) WITHOUT ROWID",
+		"CREATE INDEX _labels_element_index ON " + labelsTable + "(element_id)",
+		"CREATE TRIGGER labels_insert AFTER INSERT ON elements BEGIN " + insertLabels + "END",
+		"CREATE TRIGGER labels_delete AFTER DELETE ON elements BEGIN " + deleteLabels + "END",
+		"CREATE TRIGGER labels_update AFTER UPDATE ON elements BEGIN " + deleteLabels + insertLabels + "END",
+		"INSERT OR IGNORE INTO " + labelsTable + " (label, element_id) " +
+			"SELECT label.value, elements.id FROM elements, json_each(elements.json, '$.labels') AS label " +
+			"WHERE label.type = 'text'",
+	} {
+		if err := store.exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupLabels checks whether the store has a labels table.
+func (store *ForensicStore) setupLabels() (err error) {
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	store.labelsTable, err = tableExists(conn, labelsTable)
+	return err
+}
+
+// AddLabels adds labels to an element. Existing labels are kept.
+func (store *ForensicStore) AddLabels(id string, labels ...string) error {
+	return store.updateLabels(id, func(current []string) []string {
+		for _, label := range labels {
+			if !contains(current, label) {
+				current = append(current, label)
+			}
+		}
+		return current
+	})
+}
+
+// RemoveLabels removes labels from an element. The labels field is removed
+// if no labels are left.
+func (store *ForensicStore) RemoveLabels(id string, labels ...string) error {
+	return store.updateLabels(id, func(current []string) []string {
+		var kept []string
+		for _, label := range current {
+			if !contains(labels, label) {
+				kept = append(kept, label)
+			}
+		}
+		return kept
+	})
+}
+
+// Labels returns all labels and the number of elements that have them.
+func (store *ForensicStore) Labels() (map[string]int64, error) {
+	return store.LabelsContext(context.Background())
+}
+
+// LabelsContext is like Labels, but aborts if ctx is done.
+func (store *ForensicStore) LabelsContext(ctx context.Context) (labels map[string]int64, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	query := "SELECT label, count(*) AS count FROM " + labelsTable + " GROUP BY label"
+	if !store.labelsTable {
+		query = "SELECT label.value AS label, count(DISTINCT elements.id) AS count " +
+			"FROM elements, json_each(elements.json, '$.labels') AS label GROUP BY label.value"
+	}
+	stmt, _, err := conn.PrepareTransient(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize() // nolint:errcheck
+
+	labels = map[string]int64{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			return labels, nil
+		}
+		labels[stmt.GetText("label")] = stmt.GetInt64("count")
+	}
+}
+
+// SelectByLabel retrieves all elements that have a label.
+func (store *ForensicStore) SelectByLabel(label string) ([]JSONElement, error) {
+	return store.SelectByLabelContext(context.Background(), label)
+}
+
+// SelectByLabelContext is like SelectByLabel, but aborts if ctx is done.
+func (store *ForensicStore) SelectByLabelContext(ctx context.Context, label string) ([]JSONElement, error) {
+	rows, err := store.Find().Label(label).RowsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rows.collect()
+}
+
+func (store *ForensicStore) updateLabels(id string, update func(current []string) []string) error {
+	return store.WithTx(func(tx *ForensicStore) error {
+		element, err := tx.Get(id)
+		if err != nil {
+			return err
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(element, &fields); err != nil {
+			return err
+		}
+
+		var current []string
+		if labels, ok := fields["labels"].([]interface{}); ok {
+			for _, label := range labels {
+				if s, ok := label.(string); ok {
+					current = append(current, s)
+				}
+			}
+		}
+
+		labels := update(current)
+		if len(labels) == 0 {
+			delete(fields, "labels")
+		} else {
+			fields["labels"] = labels
+		}
+
+		element, err = json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		return tx.Update(id, element)
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
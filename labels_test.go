@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestForensicStore_Labels(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	labels, err := store.Labels()
+	assert.NoError(t, err)
+	assert.Empty(t, labels)
+
+	assert.NoError(t, store.AddLabels(ProcessElementId, "malicious", "reviewed"))
+	assert.NoError(t, store.AddLabels(ProcessElementId, "reviewed"))
+	assert.NoError(t, store.AddLabels(fooDocID, "reviewed"))
+
+	element, err := store.Get(ProcessElementId)
+	assert.NoError(t, err)
+	assert.Equal(t, `["malicious","reviewed"]`, gjson.GetBytes(element, "labels").Raw)
+	assert.Equal(t, "iptables", gjson.GetBytes(element, "name").String())
+
+	labels, err = store.Labels()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"malicious": 1, "reviewed": 2}, labels)
+
+	elements, err := store.SelectByLabel("reviewed")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{ProcessElementId, fooDocID}, ids(elements))
+
+	assert.NoError(t, store.RemoveLabels(ProcessElementId, "reviewed", "unknown"))
+	assert.NoError(t, store.RemoveLabels(fooDocID, "reviewed"))
+
+	element, err = store.Get(fooDocID)
+	assert.NoError(t, err)
+	assert.False(t, gjson.GetBytes(element, "labels").Exists())
+
+	labels, err = store.Labels()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"malicious": 1}, labels)
+
+	elements, err = store.SelectByLabel("reviewed")
+	assert.NoError(t, err)
+	assert.Empty(t, elements)
+
+	err = store.AddLabels("file--00000000-0000-0000-0000-000000000000", "malicious")
+	assert.True(t, errors.Is(err, ErrElementNotExists))
+}
+
+func TestForensicStore_SelectByLabel_index(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(newVersion3Store(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	assert.NoError(t, store.AddLabels("foo--1", "malicious"))
+	query, args, err := store.Find().Label("malicious").sql("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, queryPlan(t, store, query, args), labelsTable)
+
+	// existing labels are added to the labels table by Upgrade
+	_, err = store.Upgrade()
+	assert.NoError(t, err)
+	query, args, err = store.Find().Label("malicious").sql("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, queryPlan(t, store, query, args), "SEARCH TABLE "+labelsTable+" USING PRIMARY KEY (label=?)")
+
+	elements, err := store.SelectByLabel("malicious")
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+
+	assert.NoError(t, store.RemoveLabels("foo--1", "malicious"))
+	elements, err = store.SelectByLabel("malicious")
+	assert.NoError(t, err)
+	assert.Empty(t, elements)
+}
+
+func TestForensicStore_Labels_duplicated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(newVersion3Store(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	_, err = store.Insert(jsons(element{"id": "foo--2", "type": "foo", "labels": []string{"dup", "dup"}}))
+	assert.NoError(t, err)
+
+	// without the labels table
+	labels, err := store.Labels()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"dup": 1}, labels)
+
+	_, err = store.Upgrade()
+	assert.NoError(t, err)
+	labels, err = store.Labels()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"dup": 1}, labels)
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+)
+
+// MergeSource is the field that records the origin of merged elements. It
+// does not overwrite a "source" field of the elements and is not prefixed
+// with an underscore, because custom STIX properties must start with a letter.
+const MergeSource = "merge_source"
+
+// ErrMergeDecrypts is returned by Merge if an encrypted store would be merged
+// into an unencrypted store.
+var ErrMergeDecrypts = errors.New("cannot merge an encrypted store into an unencrypted store")
+
+// mergeNamespace is used to derive new ids for conflicting elements, so
+// merging the same store again yields the same ids.
+var mergeNamespace = uuid.MustParse("d9ab68c3-90c6-45a0-970b-ff7adbf0266a")
+
+// Merge copies the elements, files and annotations of all srcs into dst.
+// Every element is tagged with the name of its source store and the
+// acquisition host from the store metadata in the MergeSource field. Other
+// metadata and the custody events of srcs are not merged, a warning is logged
+// for them. Encrypted srcs can only be merged into an encrypted dst.
+//
+// Files are renamed like in StoreFile if a different file already exists at
+// the same path, and the "*_path" fields of the elements are rewritten
+// accordingly. Elements that already exist in dst are skipped, elements with
+// an existing id but different content get a new id. References in "*_ref"
+// and "*_refs" fields of the same source are updated to the new ids.
+func Merge(dst *ForensicStore, srcs ...*ForensicStore) error {
+	for _, src := range srcs {
+		if src.Encrypted() && !dst.Encrypted() {
+			return fmt.Errorf("could not merge %s: %w", src.url, ErrMergeDecrypts)
+		}
+		if err := merge(dst, src); err != nil {
+			return fmt.Errorf("could not merge %s: %w", src.url, err)
+		}
+	}
+	return nil
+}
+
+func merge(dst, src *ForensicStore) error {
+	metadata, err := src.Metadata()
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.Base(src.url), ".forensicstore")
+	source := map[string]interface{}{"store": name}
+	if host, ok := metadata[MetadataAcquisitionHost]; ok {
+		source["host"] = host
+	}
+	if len(metadata) > 0 {
+		log.Printf("metadata of %s is not merged", src.url)
+	}
+	events, err := src.CustodyEvents()
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		log.Printf("%d custody events of %s are not merged", len(events), src.url)
+	}
+
+	return dst.WithTx(func(tx *ForensicStore) error {
+		paths, err := mergeFiles(tx, src)
+		if err != nil {
+			return err
+		}
+
+		ids, err := conflictingIDs(tx, src, name, paths)
+		if err != nil {
+			return err
+		}
+
+		rows, err := src.queryRows(context.Background(), "SELECT json, insert_time FROM elements", nil)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			insertTime := rows.stmt.GetText("insert_time")
+			if err := mergeElement(tx, src, rows.Element(), insertTime, source, ids, paths); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// mergeElement inserts a single element of src with its insert time and its
+// annotations into dst.
+func mergeElement(dst, src *ForensicStore, element JSONElement, insertTime string, source map[string]interface{}, ids, paths map[string]string) error { // nolint:lll
+	srcID := gjson.GetBytes(element, "id").String()
+	fields, err := rewriteElement(element, ids, paths)
+	if err != nil {
+		return err
+	}
+	fields[MergeSource] = source
+	id := fields["id"].(string)
+
+	existing, err := dst.Get(id)
+	switch {
+	case err == nil:
+		equal, err := equalElements(existing, fields)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			// conflictingIDs resolves all conflicts before
+			return fmt.Errorf("element %s conflicts with an existing element", id)
+		}
+		return copyAnnotations(dst, src, srcID, id)
+	case !errors.Is(err, ErrElementNotExists):
+		return err
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.insert(context.Background(), b, insertTime); err != nil {
+		return err
+	}
+	return copyAnnotations(dst, src, srcID, id)
+}
+
+// copyAnnotations copies the annotations of an element of src to the element
+// with dstID in dst. Annotations keep their id, so copying again does not
+// duplicate them.
+func copyAnnotations(dst, src *ForensicStore, srcID, dstID string) error {
+	annotations, err := src.Annotations(srcID)
+	if err != nil {
+		return err
+	}
+	for _, annotation := range annotations {
+		annotation.ElementID = dstID
+		if err := insertAnnotation(dst.connection, annotation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conflictingIDs returns new ids for all elements of src whose id is used by
+// a different element in dst. Elements can differ only because they reference
+// other conflicting elements, so the elements of src are read again until no
+// further conflicts are found. All ids are resolved before any reference is
+// rewritten, so references never point to an unrelated element of dst.
+func conflictingIDs(dst, src *ForensicStore, name string, paths map[string]string) (map[string]string, error) {
+	ids := map[string]string{}
+	for changed := true; changed; {
+		var err error
+		changed, err = conflictingIDsPass(dst, src, name, ids, paths)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// conflictingIDsPass adds or updates the new ids of conflicting elements in
+// ids and returns whether any were changed.
+func conflictingIDsPass(dst, src *ForensicStore, name string, ids, paths map[string]string) (changed bool, err error) {
+	rows, err := src.AllRows()
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		element := rows.Element()
+		id := gjson.GetBytes(element, "id").String()
+		newID, ok := ids[id]
+		if !ok {
+			newID = id
+		}
+
+		usable, err := usableID(dst, element, id, newID, ids, paths)
+		if err != nil {
+			return false, err
+		}
+		if usable {
+			continue
+		}
+
+		newID, err = derivedID(dst, element, name, id, ids, paths)
+		if err != nil {
+			return false, err
+		}
+		ids[id] = newID
+		changed = true
+	}
+	return changed, rows.Err()
+}
+
+// derivedID returns the first id derived from the name of the source store and
+// the id of an element that is not used by a different element in dst. The
+// same id is derived if the store is merged again.
+func derivedID(dst *ForensicStore, element JSONElement, name, id string, ids, paths map[string]string) (string, error) {
+	elementType := gjson.GetBytes(element, discriminator).String()
+	for i := 0; ; i++ {
+		seed := name + "/" + id
+		if i > 0 {
+			seed += fmt.Sprintf("/%d", i)
+		}
+		candidate := fmt.Sprintf("%s--%s", elementType, uuid.NewSHA1(mergeNamespace, []byte(seed)).String())
+		usable, err := usableID(dst, element, id, candidate, ids, paths)
+		if err != nil || usable {
+			return candidate, err
+		}
+	}
+}
+
+// usableID returns whether an element of src can be merged as candidate,
+// because candidate is not used in dst or used by an equal element.
+func usableID(dst *ForensicStore, element JSONElement, id, candidate string, ids, paths map[string]string) (bool, error) {
+	previous, ok := ids[id]
+	ids[id] = candidate
+	fields, err := rewriteElement(element, ids, paths)
+	if ok {
+		ids[id] = previous
+	} else {
+		delete(ids, id)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	existing, err := dst.Get(candidate)
+	if errors.Is(err, ErrElementNotExists) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return equalElements(existing, fields)
+}
+
+// mergeFiles copies all files of src to dst and returns the new paths of
+// files that were renamed. Files that already exist in dst with the same
+// content, either at the same path or renamed by an earlier merge, are not
+// copied again.
+func mergeFiles(dst, src *ForensicStore) (map[string]string, error) {
+	files, err := src.files(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]string{}
+	for _, path := range files {
+		srcHash, err := hashFile(src.Fs, path)
+		if err != nil {
+			return nil, err
+		}
+
+		storePath, err := findFile(dst, path, srcHash)
+		if err != nil {
+			return nil, err
+		}
+		if storePath == "" {
+			storePath, err = copyFile(dst, src, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if storePath != path {
+			paths[path] = storePath
+		}
+	}
+	return paths, nil
+}
+
+// findFile returns the path of a file with the given hash at path or at one
+// of the alternative paths used by StoreFile, or "" if there is none.
+func findFile(store *ForensicStore, path, hash string) (string, error) {
+	ext := filepath.Ext(path)
+	base := path[:len(path)-len(ext)]
+	candidate := path
+	for i := 0; ; i++ {
+		candidateHash, err := hashFile(store.Fs, candidate)
+		if err != nil {
+			return "", err
+		}
+		if candidateHash == "" {
+			return "", nil
+		}
+		if candidateHash == hash {
+			return candidate, nil
+		}
+		candidate = numberedPath(base, ext, i)
+	}
+}
+
+func copyFile(dst, src *ForensicStore, path string) (storePath string, err error) {
+	srcFile, err := src.Fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+
+	storePath, dstFile, teardown, err := dst.StoreFile(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		_ = teardown()
+		return "", err
+	}
+	return storePath, teardown()
+}
+
+// rewriteElement parses an element and replaces ids in the "id", "*_ref" and
+// "*_refs" fields and paths in the "*_path" fields.
+func rewriteElement(element JSONElement, ids, paths map[string]string) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(element, &fields); err != nil {
+		return nil, err
+	}
+
+	for field, value := range fields {
+		switch {
+		case field == "id" || strings.HasSuffix(field, "_ref"):
+			if id, ok := value.(string); ok {
+				if newID, ok := ids[id]; ok {
+					fields[field] = newID
+				}
+			}
+		case strings.HasSuffix(field, "_refs"):
+			if refs, ok := value.([]interface{}); ok {
+				for i, ref := range refs {
+					if newID, ok := ids[fmt.Sprint(ref)]; ok {
+						refs[i] = newID
+					}
+				}
+			}
+		case strings.HasSuffix(field, "_path"):
+			if path, ok := value.(string); ok {
+				if newPath, ok := paths[normalizePath(path)]; ok {
+					if !strings.HasPrefix(path, "/") {
+						newPath = strings.TrimPrefix(newPath, "/")
+					}
+					fields[field] = newPath
+				}
+			}
+		}
+	}
+	return fields, nil
+}
+
+// equalElements compares two elements without their merge source.
+func equalElements(element JSONElement, fields map[string]interface{}) (bool, error) {
+	var existing map[string]interface{}
+	if err := json.Unmarshal(element, &existing); err != nil {
+		return false, err
+	}
+	delete(existing, MergeSource)
+
+	other := map[string]interface{}{}
+	for field, value := range fields {
+		if field != MergeSource {
+			other[field] = value
+		}
+	}
+	return reflect.DeepEqual(existing, other), nil
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host1, teardown1 := setupUrl(t, filepath.Join(dir, "host1.forensicstore"))
+	defer teardown1()
+	assert.NoError(t, host1.SetMetadata(MetadataAcquisitionHost, "WS01"))
+
+	// host2 has a different process and a different Amcache.hve
+	host2, teardown2 := setupUrl(t, filepath.Join(dir, "host2.forensicstore"))
+	defer teardown2()
+	assert.NoError(t, host2.Patch(ProcessElementId, JSONElement(`{"name": "iptables-legacy"}`)))
+	assert.NoError(t, host2.Fs.Remove("/WindowsAMCacheHveFile/Amcache.hve"))
+	assert.NoError(t, afero.WriteFile(host2.Fs, "/WindowsAMCacheHveFile/Amcache.hve", []byte(strings.Repeat("B", 123)), 0644))
+	relationshipID, err := host2.AddRelationship(ProcessElementId, powershellID, "related-to")
+	assert.NoError(t, err)
+	annotationID, err := host2.AddAnnotation(ProcessElementId, "analyst", "suspicious")
+	assert.NoError(t, err)
+
+	dst, teardown, err := New(filepath.Join(dir, "merged.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	assert.NoError(t, Merge(dst, host1, host2))
+
+	elements, err := dst.All()
+	assert.NoError(t, err)
+	// 7 elements of host1, the changed process, the two files referencing
+	// the changed Amcache.hve and the relationship of host2
+	assert.Len(t, elements, 11)
+
+	element, err := dst.Get(ProcessElementId)
+	assert.NoError(t, err)
+	assert.Equal(t, "iptables", gjson.GetBytes(element, "name").String())
+	assert.Equal(t, "host1", gjson.GetBytes(element, "merge_source.store").String())
+	assert.Equal(t, "WS01", gjson.GetBytes(element, "merge_source.host").String())
+
+	processes, err := dst.Find().Type("process").Where("merge_source.store", Equal, "host2").Elements()
+	assert.NoError(t, err)
+	if assert.Len(t, processes, 1) {
+		assert.Equal(t, "iptables-legacy", gjson.GetBytes(processes[0], "name").String())
+		assert.NotEqual(t, ProcessElementId, gjson.GetBytes(processes[0], "id").String())
+		assert.False(t, gjson.GetBytes(processes[0], "merge_source.host").Exists())
+
+		// references are rewritten to the new id
+		relationship, err := dst.Get(relationshipID)
+		assert.NoError(t, err)
+		assert.Equal(t, gjson.GetBytes(processes[0], "id").String(), gjson.GetBytes(relationship, "source_ref").String())
+
+		// annotations are moved to the new id
+		annotations, err := dst.Annotations(gjson.GetBytes(processes[0], "id").String())
+		assert.NoError(t, err)
+		if assert.Len(t, annotations, 1) {
+			assert.Equal(t, annotationID, annotations[0].ID)
+			assert.Equal(t, "suspicious", annotations[0].Note)
+		}
+	}
+	annotations, err := dst.Annotations(ProcessElementId)
+	assert.NoError(t, err)
+	assert.Empty(t, annotations)
+
+	files, err := dst.Find().Type("file").Where("merge_source.store", Equal, "host2").Elements()
+	assert.NoError(t, err)
+	for _, file := range files {
+		assert.Equal(t, "WindowsAMCacheHveFile/Amcache_0.hve", gjson.GetBytes(file, "export_path").String())
+	}
+	b, err := afero.ReadFile(dst.Fs, "/WindowsAMCacheHveFile/Amcache_0.hve")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("B", 123), string(b))
+
+	// merging again does not change anything
+	assert.NoError(t, Merge(dst, host1, host2))
+	elements, err = dst.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 11)
+	processes, err = dst.Find().Type("process").Where("merge_source.store", Equal, "host2").Elements()
+	assert.NoError(t, err)
+	if assert.Len(t, processes, 1) {
+		annotations, err := dst.Annotations(gjson.GetBytes(processes[0], "id").String())
+		assert.NoError(t, err)
+		assert.Len(t, annotations, 1)
+	}
+
+	flaws, err := dst.VerifyIntegrity()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
+
+func TestMerge_encrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, srcTeardown, err := NewEncrypted(filepath.Join(dir, "src.forensicstore"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcTeardown()
+	_, err = src.Insert(jsons(element{"id": "file--0c3a6b2e-7a8f-4a4e-9d2b-1c5e8f9a0b1d", "type": "file", "name": "foo.txt"}))
+	assert.NoError(t, err)
+
+	plain, plainTeardown, err := New(filepath.Join(dir, "plain.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plainTeardown()
+	assert.ErrorIs(t, Merge(plain, src), ErrMergeDecrypts)
+
+	encrypted, encryptedTeardown, err := NewEncrypted(filepath.Join(dir, "encrypted.forensicstore"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encryptedTeardown()
+	assert.NoError(t, Merge(encrypted, src))
+	elements, err := encrypted.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+}
+
+func TestMerge_updatedSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host1, teardown1 := setupUrl(t, filepath.Join(dir, "host1.forensicstore"))
+	defer teardown1()
+	host2, teardown2 := setupUrl(t, filepath.Join(dir, "host2.forensicstore"))
+	defer teardown2()
+	assert.NoError(t, host2.Patch(ProcessElementId, JSONElement(`{"name": "v1"}`)))
+	_, err = host2.AddRelationship(ProcessElementId, powershellID, "related-to")
+	assert.NoError(t, err)
+
+	dst, teardown, err := New(filepath.Join(dir, "merged.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+	assert.NoError(t, Merge(dst, host1, host2))
+
+	// the derived id of the process is already used by the first version
+	assert.NoError(t, host2.Patch(ProcessElementId, JSONElement(`{"name": "v2"}`)))
+	assert.NoError(t, Merge(dst, host1, host2))
+
+	relationships, err := dst.Find().Type("relationship").Elements()
+	assert.NoError(t, err)
+	names := map[string]bool{}
+	for _, relationship := range relationships {
+		process, err := dst.Get(gjson.GetBytes(relationship, "source_ref").String())
+		assert.NoError(t, err)
+		names[gjson.GetBytes(process, "name").String()] = true
+	}
+	assert.Equal(t, map[string]bool{"v1": true, "v2": true}, names)
+
+	// merged elements keep their insert time
+	assert.Equal(t, insertTime(t, host1, ProcessElementId), insertTime(t, dst, ProcessElementId))
+
+	flaws, err := dst.VerifyIntegrity()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+}
+
+func insertTime(t *testing.T, store *ForensicStore, id string) (insertTime string) {
+	err := sqlitex.Exec(store.connection, "SELECT insert_time FROM elements WHERE id = ?", func(stmt *sqlite.Stmt) error {
+		insertTime = stmt.GetText("insert_time")
+		return nil
+	}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return insertTime
+}
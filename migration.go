@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"fmt"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// MinVersion is the oldest store version that can be opened and upgraded.
+const MinVersion = 2
+
+// A migration upgrades a store from version from to version from+1.
+type migration struct {
+	from        int64
+	description string
+	up          func(tx *ForensicStore) error
+}
+
+// migrations must be ordered and cover every version from MinVersion to
+// Version.
+var migrations = []migration{
+	{2, "replace the full-text elements table with an indexed table", migrateElementsTable},
+	{3, "create the indexes, the labels table and the audit log added in version 4", migrateVersion3},
+}
+
+// Upgrade migrates the store to the current Version and returns the version
+// before the upgrade. All migrations are applied in a single transaction, so
+// the store is either upgraded completely or left unchanged.
+func (store *ForensicStore) Upgrade() (from int64, err error) {
+	from, err = store.pragma("user_version")
+	if err != nil {
+		return 0, err
+	}
+
+	err = store.WithTx(func(tx *ForensicStore) error {
+		for _, m := range migrations {
+			if m.from < from {
+				continue
+			}
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("could not %s: %w", m.description, err)
+			}
+			if err := tx.setPragma("user_version", m.from+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return from, err
+	}
+	return from, store.setupLabels()
+}
+
+// Backup writes a copy of the database to path. Files of stores created with
+// NewDirFS are not part of the backup.
+func (store *ForensicStore) Backup(path string) error {
+	conn, release, err := store.acquire(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	return sqlitex.Exec(conn, "VACUUM INTO ?", nil, path)
+}
+
+// migrateElementsTable converts the elements table of version 2 stores,
+// which is a FTS5 virtual table, into a regular table with indexes.
+func migrateElementsTable(tx *ForensicStore) error {
+	// views reference the elements table and are recreated afterwards
+	var views []string
+	err := sqlitex.ExecTransient(tx.connection, "SELECT name FROM sqlite_master WHERE type = 'view'",
+		func(stmt *sqlite.Stmt) error {
+			views = append(views, stmt.GetText("name"))
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	for _, view := range views {
+		if err := tx.exec(fmt.Sprintf("DROP VIEW \"%s\"", view)); err != nil {
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		"ALTER TABLE elements RENAME TO elements_v2",
+		elementsTable,
+		"INSERT INTO elements (id, json, insert_time) SELECT id, json, insert_time FROM elements_v2",
+		"DROP TABLE elements_v2",
+	} {
+		if err := tx.exec(query); err != nil {
+			return err
+		}
+	}
+	for _, index := range elementIndexes {
+		if err := tx.exec(index); err != nil {
+			return err
+		}
+	}
+	return tx.createViews()
+}
+
+// migrateVersion3 creates the element indexes and the labels table that stores
+// of version 3 lack and starts the audit log with the current elements and
+// files as baseline.
+func migrateVersion3(tx *ForensicStore) error {
+	for _, index := range elementIndexes {
+		if err := tx.exec(index); err != nil {
+			return err
+		}
+	}
+	if err := tx.createLabelsTable(); err != nil {
+		return err
+	}
+	return tx.setupAudit()
+}
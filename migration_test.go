@@ -0,0 +1,226 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, int64(MinVersion+i), m.from, m.description)
+	}
+	assert.Equal(t, int64(Version), migrations[len(migrations)-1].from+1)
+}
+
+func TestForensicStore_Upgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(copyExample(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	before, err := store.All()
+	assert.NoError(t, err)
+	flawsBefore, err := store.Validate()
+	assert.NoError(t, err)
+
+	backup := filepath.Join(dir, "backup.forensicstore")
+	assert.NoError(t, store.Backup(backup))
+
+	from, err := store.Upgrade()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), from)
+
+	version, err := store.pragma("user_version")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(Version), version)
+
+	after, err := store.All()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, before, after)
+	flawsAfter, err := store.Validate()
+	assert.NoError(t, err)
+	assert.Equal(t, flawsBefore, flawsAfter)
+
+	var objects []string
+	err = sqlitex.ExecTransient(store.connection, "SELECT type || ' ' || name AS object FROM sqlite_master "+
+		"WHERE name IN ('elements', 'elements_v2', 'type_index', 'process')", func(stmt *sqlite.Stmt) error {
+		objects = append(objects, stmt.GetText("object"))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"table elements", "index type_index", "view process"}, objects)
+
+	// views work on the new table
+	var processes int64
+	err = sqlitex.ExecTransient(store.connection, "SELECT count(*) FROM process", func(stmt *sqlite.Stmt) error {
+		processes = stmt.ColumnInt64(0)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), processes)
+
+	// upgrading again does nothing
+	from, err = store.Upgrade()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(Version), from)
+
+	// the backup is unchanged
+	backupStore, backupTeardown, err := Open(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backupTeardown()
+	version, err = backupStore.pragma("user_version")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+}
+
+func TestForensicStore_UpgradeRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(copyExample(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	// the full-text table of version 2 allows duplicate ids
+	assert.NoError(t, store.exec("INSERT INTO elements (id, json) SELECT id, json FROM elements LIMIT 1"))
+
+	_, err = store.Upgrade()
+	assert.Error(t, err)
+
+	version, err := store.pragma("user_version")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	elements, err := store.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 8)
+}
+
+func copyExample(t *testing.T, dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join("test", "forensicstore", "example1.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := filepath.Join(dir, "example1.forensicstore")
+	if err := ioutil.WriteFile(url, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+func TestForensicStore_UpgradeVersion3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(newVersion3Store(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	from, err := store.Upgrade()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), from)
+
+	var indexes []string
+	err = sqlitex.ExecTransient(store.connection, "SELECT name FROM sqlite_master WHERE type = 'index' "+
+		"AND name IN ('insert_time_index', 'source_ref_index', 'target_ref_index')", func(stmt *sqlite.Stmt) error {
+		indexes = append(indexes, stmt.GetText("name"))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"insert_time_index", "source_ref_index", "target_ref_index"}, indexes)
+
+	elements, err := store.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+}
+
+// newVersion3Store creates a store as created by version 3 in dir, which has
+// neither the indexes, the labels table nor the audit log of version 4.
+func newVersion3Store(t *testing.T, dir string) string {
+	url := filepath.Join(dir, "version3.forensicstore")
+	store, teardown, err := New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	if _, err = store.Insert(jsons(element{"id": "foo--1", "type": "foo", "name": "bar"})); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []string{"insert_time_index", "source_ref_index", "target_ref_index"} {
+		if err := store.exec("DROP INDEX " + index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, statement := range []string{
+		"DROP TRIGGER labels_insert", "DROP TRIGGER labels_delete", "DROP TRIGGER labels_update",
+		"DROP TABLE " + labelsTable, "DROP TABLE " + auditTable,
+	} {
+		if err := store.exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.setPragma("user_version", 3); err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+// queryPlan returns the details of the query plan of a query.
+func queryPlan(t *testing.T, store *ForensicStore, query string, args []interface{}) string {
+	var plan []string
+	err := sqlitex.ExecTransient(store.connection, "EXPLAIN QUERY PLAN "+query, func(stmt *sqlite.Stmt) error {
+		plan = append(plan, stmt.GetText("detail"))
+		return nil
+	}, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(plan, "\n")
+}
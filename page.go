@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidCursor is returned if the cursor of a Page cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page requests a page of elements. Elements are ordered by their insert
+// time and id. After is the cursor returned for the previous page and empty
+// for the first page.
+type Page struct {
+	After string
+	Size  int
+}
+
+// Count returns the number of elements matching the conditions of Select.
+func (store *ForensicStore) Count(conditions []map[string]string) (int64, error) {
+	return store.CountContext(context.Background(), conditions)
+}
+
+// CountContext is like Count, but aborts if ctx is done.
+func (store *ForensicStore) CountContext(ctx context.Context, conditions []map[string]string) (int64, error) {
+	where, args, err := whereClause(likeConditions(conditions))
+	if err != nil {
+		return 0, err
+	}
+	query := "SELECT count(*) AS count FROM elements"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return store.count(ctx, query, args)
+}
+
+// Count returns the number of elements matching the query. Limit and offset
+// are ignored.
+func (q *ElementQuery) Count() (int64, error) {
+	return q.CountContext(context.Background())
+}
+
+// CountContext is like Count, but aborts if ctx is done.
+func (q *ElementQuery) CountContext(ctx context.Context) (int64, error) {
+	query := *q
+	query.orderBy = nil
+	query.limit, query.offset = -1, 0
+	sql, args, err := query.sql("count(*) AS count")
+	if err != nil {
+		return 0, err
+	}
+	return q.store.count(ctx, sql, args)
+}
+
+// SelectPage is like Select, but returns a single page of elements and the
+// cursor for the next page. The cursor is empty for the last page.
+func (store *ForensicStore) SelectPage(conditions []map[string]string, page Page) (elements []JSONElement, next string, err error) { // nolint:lll
+	return store.SelectPageContext(context.Background(), conditions, page)
+}
+
+// SelectPageContext is like SelectPage, but aborts if ctx is done.
+func (store *ForensicStore) SelectPageContext(ctx context.Context, conditions []map[string]string, page Page) (elements []JSONElement, next string, err error) { // nolint:lll
+	where, args, err := whereClause(likeConditions(conditions))
+	if err != nil {
+		return nil, "", err
+	}
+	return store.page(ctx, where, args, page)
+}
+
+// SearchPage is like Search, but returns a single page of elements and the
+// cursor for the next page. The cursor is empty for the last page.
+func (store *ForensicStore) SearchPage(q string, page Page) (elements []JSONElement, next string, err error) {
+	return store.SearchPageContext(context.Background(), q, page)
+}
+
+// SearchPageContext is like SearchPage, but aborts if ctx is done.
+func (store *ForensicStore) SearchPageContext(ctx context.Context, q string, page Page) (elements []JSONElement, next string, err error) { // nolint:lll
+	return store.page(ctx, "json LIKE ?", []interface{}{"%" + q + "%"}, page)
+}
+
+// AllPage is like All, but returns a single page of elements and the cursor
+// for the next page. The cursor is empty for the last page.
+func (store *ForensicStore) AllPage(page Page) (elements []JSONElement, next string, err error) {
+	return store.AllPageContext(context.Background(), page)
+}
+
+// AllPageContext is like AllPage, but aborts if ctx is done.
+func (store *ForensicStore) AllPageContext(ctx context.Context, page Page) (elements []JSONElement, next string, err error) { // nolint:lll
+	return store.page(ctx, "", nil, page)
+}
+
+func (store *ForensicStore) count(ctx context.Context, query string, args []interface{}) (count int64, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+	defer release(&err)
+
+	stmt, _, err := conn.PrepareTransient(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Finalize() // nolint:errcheck
+	bindArgs(args)(stmt)
+
+	if _, err := stmt.Step(); err != nil {
+		return 0, err
+	}
+	return stmt.GetInt64("count"), nil
+}
+
+// page selects the elements after the cursor of page. One additional element
+// is fetched to detect the last page.
+func (store *ForensicStore) page(ctx context.Context, where string, args []interface{}, page Page) (elements []JSONElement, next string, err error) { // nolint:lll
+	query, args, err := pageQuery(where, args, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release(&err)
+
+	stmt, _, err := conn.PrepareTransient(query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer stmt.Finalize() // nolint:errcheck
+	bindArgs(args)(stmt)
+
+	elements = []JSONElement{}
+	var insertTime, id string
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, "", err
+		}
+		if !hasRow {
+			return elements, "", nil
+		}
+		if len(elements) == page.Size {
+			return elements, encodeCursor(insertTime, id), nil
+		}
+		elements = append(elements, JSONElement(stmt.GetText("json")))
+		insertTime, id = stmt.GetText("insert_time"), stmt.GetText("id")
+	}
+}
+
+// pageQuery compiles the query for page, which uses the insert_time_index.
+// Stores created before version 4 get the index with Upgrade.
+func pageQuery(where string, args []interface{}, page Page) (string, []interface{}, error) {
+	if page.Size <= 0 {
+		return "", nil, errors.New("page size must be positive")
+	}
+
+	var ands []string
+	if where != "" {
+		ands = append(ands, "("+where+")")
+	}
+	if page.After != "" {
+		insertTime, id, err := decodeCursor(page.After)
+		if err != nil {
+			return "", nil, err
+		}
+		ands = append(ands, "(insert_time, id) > (?, ?)")
+		args = append(args, insertTime, id)
+	}
+
+	query := "SELECT json, insert_time, id FROM elements"
+	if len(ands) > 0 {
+		query += " WHERE " + strings.Join(ands, " AND ")
+	}
+	query += " ORDER BY insert_time, id LIMIT ?"
+	args = append(args, page.Size+1)
+	return query, args, nil
+}
+
+func encodeCursor(insertTime, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(insertTime + "\n" + id))
+}
+
+func decodeCursor(cursor string) (insertTime, id string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), "\n", 2)
+	if len(parts) != 2 {
+		return "", "", ErrInvalidCursor
+	}
+	return parts[0], parts[1], nil
+}
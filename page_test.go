@@ -0,0 +1,159 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Count(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	tests := []struct {
+		name       string
+		conditions []map[string]string
+		want       int64
+		wantErr    bool
+	}{
+		{"Count all", nil, 7, false},
+		{"Count type", []map[string]string{{"type": "file"}}, 2, false},
+		{"Count filter", []map[string]string{{"type": "file", "name": "foo.doc"}}, 1, false},
+		{"Count or", []map[string]string{{"type": "file"}, {"type": "directory"}}, 3, false},
+		{"Count invalid field", []map[string]string{{"type'": "file"}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Count(tt.conditions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.Count() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestElementQuery_Count(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	count, err := store.Find().Type("file").OrderBy("name").Limit(1).Count()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+func TestStore_AllPage(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	all, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{1, 2, 3, 7, 10} {
+		var paged []JSONElement
+		page := Page{Size: size}
+		pages := 0
+		for {
+			elements, next, err := store.AllPage(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.LessOrEqual(t, len(elements), size)
+			paged = append(paged, elements...)
+			pages++
+			if next == "" {
+				break
+			}
+			page.After = next
+		}
+		assert.ElementsMatch(t, all, paged, "size %d", size)
+		assert.Equal(t, (len(all)+size-1)/size, pages, "size %d", size)
+	}
+}
+
+func TestStore_SelectPage(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	elements, next, err := store.SelectPage([]map[string]string{{"type": "file"}}, Page{Size: 1})
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+	assert.NotEmpty(t, next)
+
+	more, next, err := store.SelectPage([]map[string]string{{"type": "file"}}, Page{After: next, Size: 1})
+	assert.NoError(t, err)
+	assert.Len(t, more, 1)
+	assert.Empty(t, next)
+	assert.NotEqual(t, elements[0], more[0])
+}
+
+func TestStore_SearchPage(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	elements, next, err := store.SearchPage("iptables", Page{Size: 10})
+	assert.NoError(t, err)
+	assert.Len(t, elements, 1)
+	assert.Empty(t, next)
+}
+
+func TestStore_AllPage_errors(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	_, _, err := store.AllPage(Page{Size: 0})
+	assert.Error(t, err)
+
+	_, _, err = store.AllPage(Page{After: "!", Size: 1})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestStore_AllPage_index(t *testing.T) {
+	dir, err := ioutil.TempDir("", "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(newVersion3Store(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	query, args, err := pageQuery("", nil, Page{After: encodeCursor("2020-01-01T00:00:00Z", "foo--1"), Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, queryPlan(t, store, query, args), "insert_time_index")
+
+	_, err = store.Upgrade()
+	assert.NoError(t, err)
+	assert.Contains(t, queryPlan(t, store, query, args), "insert_time_index")
+}
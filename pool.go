@@ -0,0 +1,191 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"crawshaw.io/sqlite"
+
+	"github.com/forensicanalysis/forensicstore/sqlitefs"
+)
+
+// ErrPooledTransaction is returned by Begin, Commit and Rollback for stores
+// opened with OpenPool.
+var ErrPooledTransaction = errors.New("explicit transactions are not supported in pooled mode, use WithTx")
+
+var errPoolClosed = errors.New("connection pool closed")
+
+// OpenPool opens an existing Forensicstore with a pool of poolSize
+// connections. The returned store can be shared between goroutines: reads run
+// in parallel, writes are serialized internally.
+//
+// Every open Rows cursor and every file opened for reading holds one
+// connection until it is closed, so poolSize must be at least 2.
+func OpenPool(url string, poolSize int) (store *ForensicStore, teardown func() error, err error) {
+	if poolSize < 2 {
+		return nil, nil, errors.New("pool size must be at least 2")
+	}
+	return open(url, false, -1, poolSize, nil)
+}
+
+// OpenPoolEncrypted is like OpenPool, but unlocks the encrypted files of the
+// store with secret.
+func OpenPoolEncrypted(url string, secret []byte, poolSize int) (store *ForensicStore, teardown func() error, err error) { // nolint:lll
+	if poolSize < 2 {
+		return nil, nil, errors.New("pool size must be at least 2")
+	}
+	return open(url, false, -1, poolSize, secret)
+}
+
+// registerPoolFunctions adds custom sql functions to all connections of the
+// pool.
+func (store *ForensicStore) registerPoolFunctions(poolSize int) error {
+	var conns []*sqlite.Conn
+	defer func() {
+		for _, conn := range conns {
+			store.pool.Put(conn)
+		}
+	}()
+	for i := 0; i < poolSize; i++ {
+		conn := store.pool.Get(context.Background())
+		if conn == nil {
+			return errPoolClosed
+		}
+		conns = append(conns, conn)
+		if err := registerFunctions(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acquire returns a connection. For pooled stores the connection is taken from
+// the pool and write connections are serialized. The returned release function
+// is designed to be deferred. It returns the connection and replaces errors
+// caused by an interruption with ctx.Err().
+func (store *ForensicStore) acquire(ctx context.Context, write bool) (*sqlite.Conn, func(errp *error), error) {
+	if store.pool == nil {
+		return store.connection, interrupt(store.connection, ctx), nil
+	}
+
+	if write {
+		store.writeMu.Lock()
+	}
+	conn := store.pool.Get(ctx)
+	if conn == nil {
+		if write {
+			store.writeMu.Unlock()
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, errPoolClosed
+	}
+
+	return conn, func(errp *error) {
+		store.pool.Put(conn)
+		if write {
+			store.writeMu.Unlock()
+		}
+		if *errp != nil && ctx.Err() != nil {
+			*errp = ctx.Err()
+		}
+	}, nil
+}
+
+// bind returns a store that uses only conn. Stores that are not pooled are
+// already bound to a single connection.
+func (store *ForensicStore) bind(conn *sqlite.Conn) (*ForensicStore, error) {
+	if store.pool == nil {
+		return store, nil
+	}
+
+	fs := store.Fs
+	if sqliteFS, ok := fs.(*sqlitefs.FS); ok {
+		fs = sqliteFS.Bind(conn)
+	}
+	return &ForensicStore{
+		Fs: fs, connection: conn, types: store.types, user: store.user, labelsTable: store.labelsTable,
+	}, nil
+}
+
+// interrupt aborts running statements of the connection if ctx is done. The
+// returned function releases ctx and replaces errors caused by the
+// interruption with ctx.Err().
+//
+// A connection is shared by all open cursors of a store that is not pooled,
+// so every user pushes its context on a per connection stack and the
+// connection is interrupted by the most recent user that was not released yet.
+// Users can be released in any order.
+func interrupt(conn *sqlite.Conn, ctx context.Context) func(errp *error) { // nolint:golint
+	doneCh := ctx.Done()
+	if doneCh == nil {
+		return func(*error) {}
+	}
+
+	connInterrupts.Lock()
+	users, ok := connInterrupts.users[conn]
+	if !ok {
+		users = &interruptUsers{base: conn.SetInterrupt(doneCh)}
+		connInterrupts.users[conn] = users
+	} else {
+		conn.SetInterrupt(doneCh)
+	}
+	users.dones = append(users.dones, doneCh)
+	connInterrupts.Unlock()
+
+	return func(errp *error) {
+		connInterrupts.Lock()
+		for i := len(users.dones) - 1; i >= 0; i-- {
+			if users.dones[i] == doneCh {
+				users.dones = append(users.dones[:i], users.dones[i+1:]...)
+				break
+			}
+		}
+		if len(users.dones) == 0 {
+			delete(connInterrupts.users, conn)
+			conn.SetInterrupt(users.base)
+		} else {
+			conn.SetInterrupt(users.dones[len(users.dones)-1])
+		}
+		connInterrupts.Unlock()
+
+		if *errp != nil && ctx.Err() != nil {
+			*errp = ctx.Err()
+		}
+	}
+}
+
+// connInterrupts holds the interrupt channels of the active users of each
+// connection. base is the interrupt channel set before the first user.
+var connInterrupts = struct {
+	sync.Mutex
+	users map[*sqlite.Conn]*interruptUsers
+}{users: map[*sqlite.Conn]*interruptUsers{}}
+
+type interruptUsers struct {
+	base  <-chan struct{}
+	dones []<-chan struct{}
+}
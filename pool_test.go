@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupPool(t *testing.T, poolSize int) (*ForensicStore, func() error) {
+	tempDir, err := ioutil.TempDir("", "forensicstorepool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storePath := filepath.Join(tempDir, "pool.forensicstore")
+
+	_, teardown, err := New(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, teardownPool, err := OpenPool(storePath, poolSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() error {
+		defer os.RemoveAll(tempDir)
+		return teardownPool()
+	}
+}
+
+func TestOpenPool(t *testing.T) {
+	_, _, err := OpenPool("foo.forensicstore", 1)
+	assert.Error(t, err)
+
+	store, teardown := setupPool(t, 2)
+	defer teardown()
+
+	assert.Nil(t, store.Connection())
+	assert.Equal(t, ErrPooledTransaction, store.Begin())
+	assert.Equal(t, ErrPooledTransaction, store.Commit())
+	assert.Equal(t, ErrPooledTransaction, store.Rollback())
+}
+
+func TestForensicStore_pooledConcurrentAccess(t *testing.T) {
+	store, teardown := setupPool(t, 4)
+	defer teardown()
+
+	workers, elements := 8, 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for e := 0; e < elements; e++ {
+				exportPath, file, fileTeardown, err := store.StoreFile(fmt.Sprintf("worker%d/%d.txt", w, e))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if _, err = file.Write([]byte("foo")); err != nil {
+					errs <- err
+					return
+				}
+				if err = fileTeardown(); err != nil {
+					errs <- err
+					return
+				}
+
+				if _, err = store.Insert(jsons(element{"type": "foo", "export_path": exportPath, "size": 3})); err != nil {
+					errs <- err
+					return
+				}
+				if _, err = store.Select([]map[string]string{{"type": "foo"}}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	all, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, workers*elements, len(all))
+
+	flaws, err := store.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{}, flaws)
+}
+
+func TestForensicStore_pooledStoreFileSameName(t *testing.T) {
+	store, teardown := setupPool(t, 4)
+	defer teardown()
+
+	workers := 8
+
+	var wg sync.WaitGroup
+	paths := make(chan string, workers)
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			storePath, file, fileTeardown, err := store.StoreFile("out/stdout.txt")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err = file.Write([]byte(fmt.Sprint(w))); err != nil {
+				errs <- err
+				return
+			}
+			if err = fileTeardown(); err != nil {
+				errs <- err
+				return
+			}
+			paths <- storePath
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	close(paths)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	unique := map[string]bool{}
+	for path := range paths {
+		unique[path] = true
+	}
+	assert.Len(t, unique, workers)
+	assert.True(t, unique["out/stdout.txt"])
+	assert.True(t, unique[fmt.Sprintf("out/stdout_%d.txt", workers-2)])
+}
+
+func TestForensicStore_pooledWithTx(t *testing.T) {
+	store, teardown := setupPool(t, 2)
+	defer teardown()
+
+	var id, exportPath string
+	err := store.WithTx(func(tx *ForensicStore) error {
+		var err error
+		var fileTeardown func() error
+		exportPath, _, fileTeardown, err = tx.StoreFile("tx.txt")
+		if err != nil {
+			return err
+		}
+		if err = fileTeardown(); err != nil {
+			return err
+		}
+		id, err = tx.Insert(jsons(element{"type": "foo", "export_path": exportPath}))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddAnnotation(id, "alice", "suspicious")
+	assert.NoError(t, err)
+
+	err = store.Delete(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := afero.Exists(store.Fs, exportPath)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	annotations, err := store.Annotations(id)
+	assert.NoError(t, err)
+	assert.Empty(t, annotations)
+}
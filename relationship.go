@@ -0,0 +1,207 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Direction of a relationship relative to an element.
+type Direction int
+
+const (
+	// Outgoing relationships have the element as source.
+	Outgoing Direction = iota
+	// Incoming relationships have the element as target.
+	Incoming
+	// Both matches relationships in any direction.
+	Both
+)
+
+// AddRelationship adds a STIX relationship element between two existing
+// elements, e.g. AddRelationship(processID, fileID, "created"), and returns
+// its id.
+func (store *ForensicStore) AddRelationship(sourceID, targetID, relationshipType string) (id string, err error) {
+	if relationshipType == "" {
+		return "", fmt.Errorf("relationship type must not be empty")
+	}
+
+	relationship := NewRelationship()
+	relationship.RelationshipType = relationshipType
+	relationship.SourceRef = sourceID
+	relationship.TargetRef = targetID
+
+	err = store.WithTx(func(tx *ForensicStore) error {
+		for _, ref := range []string{sourceID, targetID} {
+			if _, err := tx.Get(ref); err != nil {
+				return fmt.Errorf("relationship endpoint %s: %w", ref, err)
+			}
+		}
+		id, err = tx.InsertStruct(relationship)
+		return err
+	})
+	return id, err
+}
+
+// Relationships returns the relationship elements of an element. An empty
+// relationshipType matches all relationships.
+func (store *ForensicStore) Relationships(id string, direction Direction, relationshipType string) ([]JSONElement, error) { // nolint:lll
+	return store.RelationshipsContext(context.Background(), id, direction, relationshipType)
+}
+
+// RelationshipsContext is like Relationships, but aborts if ctx is done.
+func (store *ForensicStore) RelationshipsContext(ctx context.Context, id string, direction Direction, relationshipType string) ([]JSONElement, error) { // nolint:lll
+	query, args, err := relationshipsQuery(id, direction, relationshipType)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := store.queryRows(ctx, query, bindArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return rows.collect()
+}
+
+// relationshipsQuery compiles the query for Relationships, which uses the
+// source_ref_index and target_ref_index. Stores created before version 4 get
+// the indexes with Upgrade.
+func relationshipsQuery(id string, direction Direction, relationshipType string) (string, []interface{}, error) {
+	var refs []string
+	var args []interface{}
+	if direction == Outgoing || direction == Both {
+		refs = append(refs, "json_extract(json, '$.source_ref') = ?")
+		args = append(args, id)
+	}
+	if direction == Incoming || direction == Both {
+		refs = append(refs, "json_extract(json, '$.target_ref') = ?")
+		args = append(args, id)
+	}
+	if len(refs) == 0 {
+		return "", nil, fmt.Errorf("invalid direction %d", direction)
+	}
+
+	// the unary + keeps the planner from choosing the type_index
+	query := "SELECT json FROM elements WHERE +json_extract(json, '$.type') = 'relationship' " +
+		"AND (" + strings.Join(refs, " OR ") + ")"
+	if relationshipType != "" {
+		query += " AND json_extract(json, '$.relationship_type') = ?"
+		args = append(args, relationshipType)
+	}
+	return query, args, nil
+}
+
+// Related returns the elements that are directly connected to an element by
+// relationships. An empty relationshipType follows all relationships.
+func (store *ForensicStore) Related(id string, direction Direction, relationshipType string) ([]JSONElement, error) {
+	return store.TraverseContext(context.Background(), id, direction, relationshipType, 1)
+}
+
+// RelatedContext is like Related, but aborts if ctx is done.
+func (store *ForensicStore) RelatedContext(ctx context.Context, id string, direction Direction, relationshipType string) ([]JSONElement, error) { // nolint:lll
+	return store.TraverseContext(ctx, id, direction, relationshipType, 1)
+}
+
+// Traverse returns all elements that can be reached from an element by
+// following up to depth relationships. The element itself is not returned.
+func (store *ForensicStore) Traverse(id string, direction Direction, relationshipType string, depth int) ([]JSONElement, error) { // nolint:lll
+	return store.TraverseContext(context.Background(), id, direction, relationshipType, depth)
+}
+
+// TraverseContext is like Traverse, but aborts if ctx is done.
+func (store *ForensicStore) TraverseContext(ctx context.Context, id string, direction Direction, relationshipType string, depth int) ([]JSONElement, error) { // nolint:lll
+	query, args, err := traverseQuery(id, direction, relationshipType, depth)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := store.queryRows(ctx, query, bindArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return rows.collect()
+}
+
+// traverseQuery compiles the query for Traverse. Like relationshipsQuery,
+// every step looks up the relationships of the reached elements with the
+// source_ref_index and target_ref_index.
+func traverseQuery(id string, direction Direction, relationshipType string, depth int) (string, []interface{}, error) {
+	source, target := "json_extract(edge.json, '$.source_ref')", "json_extract(edge.json, '$.target_ref')"
+	var step string
+	switch direction {
+	case Outgoing:
+		step = "SELECT " + target + ", reach.depth + 1 FROM reach JOIN elements AS edge ON " + source + " = reach.id"
+	case Incoming:
+		step = "SELECT " + source + ", reach.depth + 1 FROM reach JOIN elements AS edge ON " + target + " = reach.id"
+	case Both:
+		step = "SELECT CASE WHEN " + source + " = reach.id THEN " + target + " ELSE " + source + " END, " +
+			"reach.depth + 1 FROM reach JOIN elements AS edge ON (" + source + " = reach.id OR " + target + " = reach.id)"
+	default:
+		return "", nil, fmt.Errorf("invalid direction %d", direction)
+	}
+
+	args := []interface{}{id}
+	step += " WHERE reach.depth < ? AND +json_extract(edge.json, '$.type') = 'relationship'"
+	args = append(args, depth)
+	if relationshipType != "" {
+		step += " AND json_extract(edge.json, '$.relationship_type') = ?"
+		args = append(args, relationshipType)
+	}
+	args = append(args, id)
+
+	query := "WITH RECURSIVE reach(id, depth) AS (SELECT ?, 0 UNION " + step + ") " +
+		"SELECT json FROM elements WHERE id IN (SELECT id FROM reach) AND id != ?"
+	return query, args, nil
+}
+
+// danglingRelationships returns flaws for relationships whose source or
+// target does not exist.
+func (store *ForensicStore) danglingRelationships(ctx context.Context) (flaws []string, err error) {
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	stmt, _, err := conn.PrepareTransient("SELECT relationship.id AS id, ref.value AS ref " +
+		"FROM elements AS relationship, json_each(relationship.json) AS ref " +
+		"WHERE json_extract(relationship.json, '$.type') = 'relationship' " +
+		"AND ref.key IN ('source_ref', 'target_ref') " +
+		"AND NOT EXISTS (SELECT 1 FROM elements WHERE elements.id = ref.value) " +
+		"ORDER BY relationship.id, ref.key")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize() // nolint:errcheck
+
+	flaws = []string{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			return flaws, nil
+		}
+		flaws = append(flaws, fmt.Sprintf("missing element %s for %s", stmt.GetText("ref"), stmt.GetText("id")))
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	powershellID = "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d"
+	amcacheID    = "file--ddc3b32f-a1ea-4888-87ca-5591773f6be3"
+	fooDocID     = "file--7408798b-6f09-49dd-a3a0-c54fba59c38c"
+)
+
+func ids(elements []JSONElement) []string {
+	ids := []string{}
+	for _, element := range elements {
+		ids = append(ids, gjson.GetBytes(element, "id").String())
+	}
+	return ids
+}
+
+func setupRelationships(t *testing.T) (*ForensicStore, func() error) {
+	store, teardown := setup(t)
+
+	// iptables -created-> foo.doc -related-to-> Amcache.hve
+	// powershell -created-> Amcache.hve
+	for _, relationship := range [][3]string{
+		{ProcessElementId, fooDocID, "created"},
+		{fooDocID, amcacheID, "related-to"},
+		{powershellID, amcacheID, "created"},
+	} {
+		if _, err := store.AddRelationship(relationship[0], relationship[1], relationship[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, teardown
+}
+
+func TestForensicStore_AddRelationship(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	id, err := store.AddRelationship(ProcessElementId, fooDocID, "created")
+	assert.NoError(t, err)
+
+	relationship, err := store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "relationship", gjson.GetBytes(relationship, "type").String())
+	assert.Equal(t, ProcessElementId, gjson.GetBytes(relationship, "source_ref").String())
+	assert.Equal(t, fooDocID, gjson.GetBytes(relationship, "target_ref").String())
+	assert.Equal(t, "created", gjson.GetBytes(relationship, "relationship_type").String())
+
+	_, err = store.AddRelationship(ProcessElementId, "file--00000000-0000-0000-0000-000000000000", "created")
+	assert.True(t, errors.Is(err, ErrElementNotExists))
+
+	_, err = store.AddRelationship("file--00000000-0000-0000-0000-000000000000", fooDocID, "created")
+	assert.True(t, errors.Is(err, ErrElementNotExists))
+
+	_, err = store.AddRelationship(ProcessElementId, fooDocID, "")
+	assert.Error(t, err)
+
+	count, err := store.Count([]map[string]string{{"type": "relationship"}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func TestForensicStore_Related(t *testing.T) {
+	store, teardown := setupRelationships(t)
+	defer teardown()
+
+	tests := []struct {
+		name             string
+		id               string
+		direction        Direction
+		relationshipType string
+		want             []string
+		wantErr          bool
+	}{
+		{"Outgoing", ProcessElementId, Outgoing, "", []string{fooDocID}, false},
+		{"Incoming", amcacheID, Incoming, "", []string{fooDocID, powershellID}, false},
+		{"Incoming type", amcacheID, Incoming, "created", []string{powershellID}, false},
+		{"Both", fooDocID, Both, "", []string{ProcessElementId, amcacheID}, false},
+		{"None", ProcessElementId, Incoming, "", []string{}, false},
+		{"Invalid direction", ProcessElementId, Direction(42), "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Related(tt.id, tt.direction, tt.relationshipType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.Related() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.ElementsMatch(t, tt.want, ids(got))
+			}
+		})
+	}
+}
+
+func TestForensicStore_Traverse(t *testing.T) {
+	store, teardown := setupRelationships(t)
+	defer teardown()
+
+	tests := []struct {
+		name             string
+		id               string
+		direction        Direction
+		relationshipType string
+		depth            int
+		want             []string
+	}{
+		{"Depth 1", ProcessElementId, Outgoing, "", 1, []string{fooDocID}},
+		{"Depth 2", ProcessElementId, Outgoing, "", 2, []string{fooDocID, amcacheID}},
+		{"Type", ProcessElementId, Outgoing, "created", 2, []string{fooDocID}},
+		{"Incoming", amcacheID, Incoming, "", 5, []string{fooDocID, powershellID, ProcessElementId}},
+		{"Both", ProcessElementId, Both, "", 3, []string{fooDocID, amcacheID, powershellID}},
+		{"Depth 0", ProcessElementId, Both, "", 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Traverse(tt.id, tt.direction, tt.relationshipType, tt.depth)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, ids(got))
+		})
+	}
+}
+
+func TestForensicStore_Relationships(t *testing.T) {
+	store, teardown := setupRelationships(t)
+	defer teardown()
+
+	relationships, err := store.Relationships(amcacheID, Incoming, "")
+	assert.NoError(t, err)
+	assert.Len(t, relationships, 2)
+
+	relationships, err = store.Relationships(amcacheID, Outgoing, "")
+	assert.NoError(t, err)
+	assert.Len(t, relationships, 0)
+
+	relationships, err = store.Relationships(fooDocID, Both, "created")
+	assert.NoError(t, err)
+	assert.Len(t, relationships, 1)
+}
+
+func TestForensicStore_Validate_relationships(t *testing.T) {
+	store, teardown := setupRelationships(t)
+	defer teardown()
+
+	err := store.Delete(fooDocID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flaws, err := store.danglingRelationships(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, flaws, 2)
+}
+
+func TestForensicStore_Relationships_index(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relationships")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := Open(newVersion3Store(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	relationships, args, err := relationshipsQuery("foo--1", Both, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	traverse, traverseArgs, err := traverseQuery("foo--1", Both, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, queryPlan(t, store, relationships, args), "source_ref_index")
+	assert.NotContains(t, queryPlan(t, store, traverse, traverseArgs), "source_ref_index")
+
+	_, err = store.Upgrade()
+	assert.NoError(t, err)
+	for _, plan := range []string{queryPlan(t, store, relationships, args), queryPlan(t, store, traverse, traverseArgs)} {
+		assert.Contains(t, plan, "source_ref_index")
+		assert.Contains(t, plan, "target_ref_index")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"crawshaw.io/sqlite"
+)
+
+// Rows is a cursor over the elements of a query. Rows must be closed after
+// use, unless Next returned false.
+//
+//	rows, err := store.AllRows()
+//	if err != nil {
+//	    return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//	    fmt.Println(string(rows.Element()))
+//	}
+//	return rows.Err()
+type Rows struct {
+	stmt    *sqlite.Stmt
+	element JSONElement
+	err     error
+	release func(errp *error)
+}
+
+func newRows(stmt *sqlite.Stmt, release func(errp *error)) *Rows {
+	return &Rows{stmt: stmt, release: release}
+}
+
+// Next advances to the next element. It returns false if no elements are
+// left or an error occurred.
+func (rows *Rows) Next() bool {
+	if rows.stmt == nil {
+		return false
+	}
+
+	hasRow, err := rows.stmt.Step()
+	if err != nil {
+		rows.err = err
+	}
+	if err != nil || !hasRow {
+		rows.element = nil
+		if err := rows.Close(); err != nil && rows.err == nil {
+			rows.err = err
+		}
+		return false
+	}
+
+	rows.element = JSONElement(rows.stmt.GetText("json"))
+	return true
+}
+
+// Element returns the current element.
+func (rows *Rows) Element() JSONElement {
+	return rows.element
+}
+
+// Err returns the error, if any, that was encountered during iteration.
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Close finalizes the underlying statement and releases the connection. Close
+// can be called multiple times.
+func (rows *Rows) Close() error {
+	if rows.stmt == nil {
+		return nil
+	}
+	stmt := rows.stmt
+	rows.stmt = nil
+	err := stmt.Finalize()
+	rows.release(&rows.err)
+	return err
+}
+
+// collect reads all remaining elements and closes the rows.
+func (rows *Rows) collect() (elements []JSONElement, err error) {
+	elements = []JSONElement{}
+	for rows.Next() {
+		elements = append(elements, rows.Element())
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return elements, nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRows(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCount int
+		wantErr   bool
+	}{
+		{"All", "SELECT json FROM elements", 7, false},
+		{"Empty", "SELECT json FROM elements WHERE id = 'foo'", 0, false},
+		{"Runtime error", "SELECT json FROM elements WHERE json_extract(json, '$.[') = 1", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := store.QueryRows(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+
+			count := 0
+			for rows.Next() {
+				count++
+			}
+			if (rows.Err() != nil) != tt.wantErr {
+				t.Errorf("Rows.Err() error = %v, wantErr %v", rows.Err(), tt.wantErr)
+			}
+			assert.Equal(t, tt.wantCount, count)
+			assert.False(t, rows.Next())
+			assert.NoError(t, rows.Close())
+		})
+	}
+}
+
+func TestRows_nested(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		elements, err := store.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += len(elements)
+	}
+	assert.NoError(t, rows.Err())
+	assert.Equal(t, 7*7, count)
+}
+
+func TestForensicStore_Iterate(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	count := 0
+	err := store.Iterate("SELECT json FROM elements", func(element JSONElement) error {
+		count++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, count)
+
+	err = store.Iterate("SELECT json FROM elements", func(element JSONElement) error {
+		return ErrElementNotExists
+	})
+	assert.Equal(t, ErrElementNotExists, err)
+}
+
+func TestRows_closeOutOfOrder(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	r1, err := store.AllRowsContext(ctx1)
+	assert.NoError(t, err)
+	r2, err := store.AllRowsContext(ctx2)
+	assert.NoError(t, err)
+	assert.NoError(t, r1.Close())
+	assert.NoError(t, r2.Close())
+
+	// the context of a closed cursor must not interrupt later queries
+	cancel1()
+	time.Sleep(10 * time.Millisecond)
+
+	elements, err := store.All()
+	assert.NoError(t, err)
+	assert.Len(t, elements, 7)
+	_, err = store.Insert(jsons(element{"id": "file--0c3a6b2e-7a8f-4a4e-9d2b-1c5e8f9a0b1d", "type": "file", "name": "foo.txt"}))
+	assert.NoError(t, err)
+}
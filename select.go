@@ -0,0 +1,245 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"crawshaw.io/sqlite"
+)
+
+// Operators that can be used in a Condition.
+const (
+	Equal        = "="
+	NotEqual     = "!="
+	Less         = "<"
+	LessEqual    = "<="
+	Greater      = ">"
+	GreaterEqual = ">="
+	Like         = "LIKE"
+	Glob         = "GLOB"
+	Regexp       = "REGEXP"
+	In           = "IN"
+	IsNull       = "IS NULL"
+	IsNotNull    = "IS NOT NULL"
+)
+
+var binaryOperators = map[string]bool{
+	Equal: true, NotEqual: true, Less: true, LessEqual: true, Greater: true, GreaterEqual: true,
+	Like: true, Glob: true, Regexp: true,
+}
+
+// fieldPattern matches JSON paths like "origin.path" or "values[0].name".
+var fieldPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\[[0-9]+\])*(\.[A-Za-z0-9_\-]+(\[[0-9]+\])*)*$`)
+
+// Condition compares a field of the elements with a value. Field is a JSON
+// path without the leading "$.", e.g. "origin.path". Value must be a slice
+// for the In operator and is ignored for IsNull and IsNotNull.
+type Condition struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+// Conditions are combined with AND.
+type Conditions []Condition
+
+// SelectWhere retrieves all elements that match any of the given conditions.
+// Values are bound as parameters.
+func (store *ForensicStore) SelectWhere(conditions ...Conditions) (elements []JSONElement, err error) {
+	rows, err := store.SelectWhereRowsContext(context.Background(), conditions...)
+	if err != nil {
+		return nil, err
+	}
+	return rows.collect()
+}
+
+// SelectWhereRowsContext is like SelectWhere, but returns a cursor over the
+// elements, that aborts if ctx is done.
+func (store *ForensicStore) SelectWhereRowsContext(ctx context.Context, conditions ...Conditions) (*Rows, error) {
+	where, args, err := whereClause(conditions)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT json FROM elements"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return store.queryRows(ctx, query, bindArgs(args))
+}
+
+// likeConditions converts conditions of Select to Conditions.
+func likeConditions(conditions []map[string]string) []Conditions {
+	var ors []Conditions
+	for _, condition := range conditions {
+		var ands Conditions
+		for key, value := range condition {
+			ands = append(ands, Condition{Field: key, Operator: Like, Value: value})
+		}
+		ors = append(ors, ands)
+	}
+	return ors
+}
+
+// jsonPath validates a field and returns the corresponding JSON path.
+func jsonPath(field string) (string, error) {
+	if !fieldPattern.MatchString(field) {
+		return "", fmt.Errorf("invalid field '%s'", field)
+	}
+	return "'$." + field + "'", nil
+}
+
+// whereClause builds an SQL expression from the conditions. Conditions are
+// combined with OR, the inner conditions with AND.
+func whereClause(conditions []Conditions) (string, []interface{}, error) {
+	var ors []string
+	var args []interface{}
+	for _, condition := range conditions {
+		var ands []string
+		for _, c := range condition {
+			expr, exprArgs, err := c.sql()
+			if err != nil {
+				return "", nil, err
+			}
+			ands = append(ands, expr)
+			args = append(args, exprArgs...)
+		}
+		if len(ands) > 0 {
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+	}
+	return strings.Join(ors, " OR "), args, nil
+}
+
+func (c Condition) sql() (string, []interface{}, error) {
+	path, err := jsonPath(c.Field)
+	if err != nil {
+		return "", nil, err
+	}
+	field := "json_extract(json, " + path + ")"
+
+	operator := strings.ToUpper(c.Operator)
+	switch {
+	case binaryOperators[operator]:
+		return field + " " + operator + " ?", []interface{}{c.Value}, nil
+	case operator == IsNull || operator == IsNotNull:
+		return field + " " + operator, nil, nil
+	case operator == In:
+		values, err := toSlice(c.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(values) == 0 {
+			return "0", nil, nil
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		return field + " IN (" + placeholders + ")", values, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported operator '%s'", c.Operator)
+	}
+}
+
+func toSlice(value interface{}) ([]interface{}, error) {
+	switch values := value.(type) {
+	case []interface{}:
+		return values, nil
+	case []string:
+		var s []interface{}
+		for _, v := range values {
+			s = append(s, v)
+		}
+		return s, nil
+	case []int:
+		var s []interface{}
+		for _, v := range values {
+			s = append(s, v)
+		}
+		return s, nil
+	case []float64:
+		var s []interface{}
+		for _, v := range values {
+			s = append(s, v)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("value of IN must be a slice, is %T", value)
+	}
+}
+
+// bindArgs returns a function that binds args as positional parameters.
+func bindArgs(args []interface{}) func(stmt *sqlite.Stmt) {
+	return func(stmt *sqlite.Stmt) {
+		for i, arg := range args {
+			bindValue(stmt, i+1, arg)
+		}
+	}
+}
+
+func bindValue(stmt *sqlite.Stmt, param int, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		stmt.BindNull(param)
+	case string:
+		stmt.BindText(param, v)
+	case bool:
+		stmt.BindBool(param, v)
+	case int:
+		stmt.BindInt64(param, int64(v))
+	case int64:
+		stmt.BindInt64(param, v)
+	case float64:
+		stmt.BindFloat(param, v)
+	case []byte:
+		stmt.BindBytes(param, v)
+	default:
+		stmt.BindText(param, fmt.Sprint(v))
+	}
+}
+
+var regexpCache sync.Map
+
+// registerFunctions adds custom sql functions to a connection.
+func registerFunctions(conn *sqlite.Conn) error {
+	// X REGEXP Y calls regexp(Y, X)
+	return conn.CreateFunction("regexp", true, 2, func(ctx sqlite.Context, values ...sqlite.Value) {
+		pattern := values[0].Text()
+		re, ok := regexpCache.Load(pattern)
+		if !ok {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				ctx.ResultError(err)
+				return
+			}
+			re, _ = regexpCache.LoadOrStore(pattern, compiled)
+		}
+		if re.(*regexp.Regexp).MatchString(values[1].Text()) {
+			ctx.ResultInt(1)
+		} else {
+			ctx.ResultInt(0)
+		}
+	}, nil, nil)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_SelectWhere(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	tests := []struct {
+		name         string
+		conditions   []Conditions
+		wantElements int
+		wantErr      bool
+	}{
+		{"Equal", []Conditions{{{"type", Equal, "file"}}}, 2, false},
+		{"Not equal", []Conditions{{{"type", NotEqual, "file"}}}, 5, false},
+		{"Less", []Conditions{{{"size", Less, 200}}}, 2, false},
+		{"Greater equal", []Conditions{{{"size", GreaterEqual, 124}}}, 0, false},
+		{"Like", []Conditions{{{"name", Like, "%.doc"}}}, 1, false},
+		{"Glob", []Conditions{{{"name", Glob, "*.hve"}}}, 1, false},
+		{"Regexp", []Conditions{{{"name", Regexp, "^ip[0-9]*tables$"}}}, 1, false},
+		{"In", []Conditions{{{"type", In, []string{"file", "directory"}}}}, 3, false},
+		{"In empty", []Conditions{{{"type", In, []string{}}}}, 0, false},
+		{"Is null", []Conditions{{{"size", IsNull, nil}}}, 5, false},
+		{"Is not null", []Conditions{{{"size", IsNotNull, nil}}}, 2, false},
+		{"Nested field", []Conditions{{{"origin.path", Equal, "C:\\Users\\bob\\Downloads\\foo.doc"}}}, 1, false},
+		{"Array field", []Conditions{{{"values[0].name", Equal, "ACP"}}}, 1, false},
+		{"And", []Conditions{{{"type", Equal, "file"}, {"name", Equal, "foo.doc"}}}, 1, false},
+		{"Or", []Conditions{{{"type", Equal, "file"}}, {{"type", Equal, "directory"}}}, 3, false},
+		{"No conditions", nil, 7, false},
+		{"Quote in value", []Conditions{{{"name", Equal, "foo'.doc"}}}, 0, false},
+		{"Injection in value", []Conditions{{{"type", Equal, "x' OR '1'='1"}}}, 0, false},
+		{"Invalid field", []Conditions{{{"type') OR 1=1 --", Equal, "file"}}}, 0, true},
+		{"Invalid operator", []Conditions{{{"type", "= 1 OR 1 =", "file"}}}, 0, true},
+		{"Invalid regexp", []Conditions{{{"name", Regexp, "("}}}, 0, true},
+		{"Invalid in", []Conditions{{{"type", In, "file"}}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotElements, err := store.SelectWhere(tt.conditions...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.SelectWhere() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.EqualValues(t, tt.wantElements, len(gotElements))
+		})
+	}
+}
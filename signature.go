@@ -0,0 +1,165 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// ErrNoSignature is returned by VerifySignature if the store was not signed
+// with the key.
+var ErrNoSignature = errors.New("no signature for public key")
+
+// ErrInvalidSignature is returned by VerifySignature if the signature does not
+// match the current content of the store.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// signaturesTable stores all signatures of the store.
+const signaturesTable = "_signatures"
+
+// Digest returns a SHA-256 digest over all elements and files of the store.
+// Elements are ordered by id and files by path, so the digest does not depend
+// on the order of insertion.
+func (store *ForensicStore) Digest() ([]byte, error) {
+	return store.DigestContext(context.Background())
+}
+
+// DigestContext is like Digest, but aborts if ctx is done.
+func (store *ForensicStore) DigestContext(ctx context.Context) ([]byte, error) {
+	h := sha256.New()
+
+	rows, err := store.queryRows(ctx, "SELECT json, id FROM elements ORDER BY id", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		fmt.Fprintf(h, "element\x00%s\x00%s\n", rows.stmt.GetText("id"), hashBytes(rows.Element()))
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	files, err := store.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		fileHash, err := hashFile(store.Fs, path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "file\x00%s\x00%s\n", path, fileHash)
+	}
+	return h.Sum(nil), nil
+}
+
+// Sign signs the digest of the store with an Ed25519 key and stores the
+// signature in the database. A store can be signed with multiple keys. The
+// digest is computed in the same transaction as the signature is stored, so
+// concurrent writes can not change the store in between.
+func (store *ForensicStore) Sign(privateKey ed25519.PrivateKey) error {
+	return store.WithTx(func(tx *ForensicStore) error {
+		digest, err := tx.Digest()
+		if err != nil {
+			return err
+		}
+		signature := ed25519.Sign(privateKey, digest)
+		publicKey := privateKey.Public().(ed25519.PublicKey)
+
+		err = tx.exec("CREATE TABLE IF NOT EXISTS " + signaturesTable + " (" +
+			"id INTEGER PRIMARY KEY AUTOINCREMENT, algorithm TEXT NOT NULL, public_key TEXT NOT NULL, " +
+			"digest TEXT NOT NULL, signature TEXT NOT NULL, time TEXT NOT NULL)")
+		if err != nil {
+			return err
+		}
+
+		return sqlitex.Exec(tx.connection, "INSERT INTO "+signaturesTable+" "+
+			"(algorithm, public_key, digest, signature, time) VALUES (?, ?, ?, ?, ?)", nil,
+			"ed25519",
+			base64.StdEncoding.EncodeToString(publicKey),
+			fmt.Sprintf("%x", digest),
+			base64.StdEncoding.EncodeToString(signature),
+			time.Now().UTC().Format(time.RFC3339Nano),
+		)
+	})
+}
+
+// VerifySignature checks the latest signature of the public key against the
+// current content of the store. It returns ErrNoSignature if the store was not
+// signed with the key and ErrInvalidSignature if the store was modified after
+// signing.
+func (store *ForensicStore) VerifySignature(publicKey ed25519.PublicKey) error {
+	signature, err := store.signature(publicKey)
+	if err != nil {
+		return err
+	}
+
+	digest, err := store.Digest()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, digest, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (store *ForensicStore) signature(publicKey ed25519.PublicKey) (signature []byte, err error) {
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	exists, err := tableExists(conn, signaturesTable)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoSignature
+	}
+
+	var encoded string
+	err = sqlitex.Exec(conn, "SELECT signature FROM "+signaturesTable+" "+
+		"WHERE algorithm = 'ed25519' AND public_key = ? ORDER BY id DESC LIMIT 1",
+		func(stmt *sqlite.Stmt) error {
+			encoded = stmt.GetText("signature")
+			return nil
+		}, base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil {
+		return nil, err
+	}
+	if encoded == "" {
+		return nil, ErrNoSignature
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForensicStore_Digest(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	digest, err := store.Digest()
+	assert.NoError(t, err)
+	assert.Len(t, digest, 32)
+
+	// annotations are not part of the digest, labels are
+	_, err = store.AddAnnotation(ProcessElementId, "alice", "note")
+	assert.NoError(t, err)
+	unchanged, err := store.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest, unchanged)
+
+	assert.NoError(t, store.AddLabels(ProcessElementId, "reviewed"))
+	changed, err := store.Digest()
+	assert.NoError(t, err)
+	assert.NotEqual(t, digest, changed)
+}
+
+func TestForensicStore_Sign(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, otherPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(store *ForensicStore) error
+		key    ed25519.PublicKey
+		want   error
+	}{
+		{"valid", func(store *ForensicStore) error { return nil }, publicKey, nil},
+		{"second key", func(store *ForensicStore) error { return nil }, otherPublicKey, nil},
+		{"unknown key", func(store *ForensicStore) error { return nil }, make(ed25519.PublicKey, ed25519.PublicKeySize), ErrNoSignature},
+		{"element modified", func(store *ForensicStore) error {
+			return store.exec("UPDATE elements SET json = json_set(json, '$.name', 'x') WHERE id = '" + ProcessElementId + "'")
+		}, publicKey, ErrInvalidSignature},
+		{"element inserted", func(store *ForensicStore) error {
+			_, err := store.Insert(jsons(element{"type": "foo"}))
+			return err
+		}, publicKey, ErrInvalidSignature},
+		{"file removed", func(store *ForensicStore) error {
+			return store.Fs.Remove("/IPTablesRules/stdout")
+		}, publicKey, ErrInvalidSignature},
+		{"file added", func(store *ForensicStore) error {
+			f, err := store.Fs.Create("/evil")
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		}, publicKey, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, teardown := setup(t)
+			defer teardown()
+
+			assert.NoError(t, store.Sign(privateKey))
+			assert.NoError(t, store.Sign(otherPrivateKey))
+			if err := tt.modify(store); err != nil {
+				t.Fatal(err)
+			}
+
+			err := store.VerifySignature(tt.key)
+			assert.True(t, errors.Is(err, tt.want), "got %v, want %v", err, tt.want)
+		})
+	}
+}
+
+func TestForensicStore_VerifySignature_unsigned(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, errors.Is(store.VerifySignature(publicKey), ErrNoSignature))
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package sqlitefs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// The content of deduplicated files is stored once in the sqlar_blobs table,
+// which counts the references to every blob. The sqlar_refs table references
+// the blob of every deduplicated file, whose sqlar row holds no data.
+const blobsTable = `CREATE TABLE IF NOT EXISTS sqlar_blobs(
+  hash TEXT PRIMARY KEY,  -- sha256, or hmac-sha256 if encrypted, of the uncompressed content
+  refs INT NOT NULL,      -- number of files with this content
+  data BLOB               -- compressed content
+);`
+
+const refsTable = `CREATE TABLE IF NOT EXISTS sqlar_refs(
+  name TEXT PRIMARY KEY,  -- name of the file in sqlar
+  hash TEXT NOT NULL      -- hash of the blob in sqlar_blobs
+);`
+
+// deduplication is shared by all file systems of a database.
+type deduplication struct {
+	enabled bool
+}
+
+// Usage describes the storage used by the files. CompressedSize is the size
+// the files would need without deduplication.
+type Usage struct {
+	Files          int64 `json:"files"`
+	Size           int64 `json:"size"`
+	CompressedSize int64 `json:"compressed_size"`
+	SavedSize      int64 `json:"saved_size"`
+}
+
+// Deduplicated returns whether files with the same content are stored once.
+func (fs *FS) Deduplicated() bool {
+	return fs.dedup.enabled
+}
+
+// EnableDeduplication stores the content of all files that are written
+// afterwards only once. Existing files are not deduplicated. The content is
+// identified by its SHA-256, or by its HMAC-SHA256 if encryption is enabled,
+// so the hashes do not reveal the content of encrypted files.
+func (fs *FS) EnableDeduplication() error {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, table := range []string{blobsTable, refsTable} {
+		if err := exec(conn.Prep(table)); err != nil {
+			return err
+		}
+	}
+	fs.dedup.enabled = true
+	return nil
+}
+
+// Usage returns the number of files, their uncompressed size, the size of
+// their compressed content and the space saved by deduplication.
+func (fs *FS) Usage() (usage Usage, err error) {
+	conn, release, err := fs.acquire(false)
+	if err != nil {
+		return usage, err
+	}
+	defer release()
+
+	err = sqlitex.Exec(conn, "SELECT count(*) AS files, ifnull(sum(sz), 0) AS size, "+
+		"ifnull(sum(length(data)), 0) AS compressed FROM sqlar WHERE data IS NOT NULL OR sz != 0",
+		func(stmt *sqlite.Stmt) error {
+			usage.Files = stmt.GetInt64("files")
+			usage.Size = stmt.GetInt64("size")
+			usage.CompressedSize = stmt.GetInt64("compressed")
+			return nil
+		})
+	if err != nil || !fs.dedup.enabled {
+		return usage, err
+	}
+
+	// add the size of the blobs for every reference
+	err = sqlitex.Exec(conn, "SELECT ifnull(sum(length(data) * refs), 0) AS logical, "+
+		"ifnull(sum(length(data)), 0) AS stored FROM sqlar_blobs",
+		func(stmt *sqlite.Stmt) error {
+			usage.CompressedSize += stmt.GetInt64("logical")
+			usage.SavedSize = stmt.GetInt64("logical") - stmt.GetInt64("stored")
+			return nil
+		})
+	return usage, err
+}
+
+// setupDeduplication reads the deduplication mode of the database.
+func (fs *FS) setupDeduplication(conn *sqlite.Conn) error {
+	fs.dedup = &deduplication{}
+	stmt := conn.Prep("SELECT count(*) AS count FROM sqlite_master WHERE type = 'table' AND name = 'sqlar_refs'")
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	fs.dedup.enabled = stmt.GetInt64("count") > 0
+	return stmt.Reset()
+}
+
+// contentHash returns the hash that identifies the content of deduplicated
+// files.
+func (fs *FS) contentHash() hash.Hash {
+	if fs != nil && fs.encryption != nil && fs.encryption.macKey != nil {
+		return hmac.New(sha256.New, fs.encryption.macKey)
+	}
+	return sha256.New()
+}
+
+// storeDeduplicated stores the content of the write buffer as blob, if no
+// blob with the same hash exists, and references it from the file.
+func (i *item) storeDeduplicated(conn *sqlite.Conn, size int64) (err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	hash := hex.EncodeToString(i.hash.Sum(nil))
+	stmt := conn.Prep("UPDATE sqlar_blobs SET refs = refs + 1 WHERE hash = $hash")
+	stmt.SetText("$hash", hash)
+	if err := exec(stmt); err != nil {
+		return err
+	}
+
+	if conn.Changes() == 0 {
+		stmt = conn.Prep("INSERT INTO sqlar_blobs (hash, refs, data) VALUES ($hash, 1, $data)")
+		stmt.SetText("$hash", hash)
+		stmt.SetZeroBlob("$data", size)
+		if err := exec(stmt); err != nil {
+			return err
+		}
+
+		data, err := conn.OpenBlob("", "sqlar_blobs", "data", conn.LastInsertRowID(), true)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(data, i.writeBuffer); err != nil {
+			data.Close()
+			return err
+		}
+		if err := data.Close(); err != nil {
+			return err
+		}
+	}
+
+	stmt = conn.Prep("INSERT INTO sqlar_refs (name, hash) VALUES ($name, $hash)")
+	stmt.SetText("$name", i.path)
+	stmt.SetText("$hash", hash)
+	if err := exec(stmt); err != nil {
+		return err
+	}
+
+	stmt = conn.Prep(`UPDATE sqlar SET sz = $sz, data = zeroblob(0) WHERE name = $name`)
+	stmt.SetText("$name", i.path)
+	stmt.SetInt64("$sz", i.size)
+	return exec(stmt)
+}
+
+// blobRef returns the hash and the rowid of the blob of a deduplicated file.
+// The hash is empty if the file is not deduplicated.
+func (fs *FS) blobRef(conn *sqlite.Conn, name string) (hash string, rowID int64, err error) {
+	if fs == nil || fs.dedup == nil || !fs.dedup.enabled {
+		return "", 0, nil
+	}
+
+	stmt := conn.Prep("SELECT sqlar_refs.hash AS hash, sqlar_blobs.rowid AS rowid FROM sqlar_refs " +
+		"LEFT JOIN sqlar_blobs ON sqlar_blobs.hash = sqlar_refs.hash WHERE name = $name")
+	defer stmt.Reset() // nolint:errcheck
+	stmt.SetText("$name", name)
+	hasRow, err := stmt.Step()
+	if err != nil || !hasRow {
+		return "", 0, err
+	}
+	hash = stmt.GetText("hash")
+	if stmt.ColumnType(stmt.ColumnIndex("rowid")) == sqlite.SQLITE_NULL {
+		return "", 0, fmt.Errorf("blob %s does not exist", hash)
+	}
+	return hash, stmt.GetInt64("rowid"), nil
+}
+
+// verifyReader returns an error at the end of the content if its hash does
+// not match the hash of the blob.
+type verifyReader struct {
+	io.Reader
+	hash hash.Hash
+	want string
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.Reader.Read(p)
+	v.hash.Write(p[:n]) // nolint:errcheck
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.want {
+		return n, fmt.Errorf("content does not match blob %s", v.want)
+	}
+	return n, err
+}
+
+func (v *verifyReader) Close() error {
+	if closer, ok := v.Reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// releaseBlobs removes the references of all files matching the where
+// clause and deletes blobs that are no longer referenced.
+func (fs *FS) releaseBlobs(conn *sqlite.Conn, where string, name string) error {
+	if !fs.dedup.enabled {
+		return nil
+	}
+
+	stmt := conn.Prep("UPDATE sqlar_blobs SET refs = refs - " +
+		"(SELECT count(*) FROM sqlar_refs WHERE " + where + " AND sqlar_refs.hash = sqlar_blobs.hash) " +
+		"WHERE hash IN (SELECT hash FROM sqlar_refs WHERE " + where + ")")
+	stmt.SetText("$name", name)
+	if err := exec(stmt); err != nil {
+		return err
+	}
+	stmt = conn.Prep("DELETE FROM sqlar_refs WHERE " + where)
+	stmt.SetText("$name", name)
+	if err := exec(stmt); err != nil {
+		return err
+	}
+	return exec(conn.Prep("DELETE FROM sqlar_blobs WHERE refs <= 0"))
+}
+
+// renameRef moves the reference of a deduplicated file to a new name.
+func (fs *FS) renameRef(conn *sqlite.Conn, oldname, newname string) error {
+	if !fs.dedup.enabled {
+		return nil
+	}
+	stmt := conn.Prep("UPDATE sqlar_refs SET name = $newname WHERE name = $oldname")
+	stmt.SetText("$oldname", oldname)
+	stmt.SetText("$newname", newname)
+	return exec(stmt)
+}
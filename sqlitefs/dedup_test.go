@@ -0,0 +1,267 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package sqlitefs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/spf13/afero"
+)
+
+func blobCount(t *testing.T, fs *FS) (blobs, refs int64) {
+	err := sqlitex.Exec(fs.cursor, "SELECT count(*), ifnull(sum(refs), 0) FROM sqlar_blobs", func(stmt *sqlite.Stmt) error {
+		blobs, refs = stmt.ColumnInt64(0), stmt.ColumnInt64(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs, refs
+}
+
+func TestFS_Deduplication(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+	db := filepath.Join(tempDir, "test.db")
+
+	fs, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.EnableDeduplication(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if !fs.Deduplicated() {
+		t.Fatal("Deduplicated() = false")
+	}
+
+	data := bytes.Repeat([]byte("forensicstore"), 1000)
+	for _, name := range []string{"/a/1.txt", "/a/2.txt", "/b/3.txt"} {
+		if err := afero.WriteFile(fs, name, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := afero.WriteFile(fs, "/other.txt", []byte("other"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if blobs, refs := blobCount(t, fs); blobs != 2 || refs != 4 {
+		t.Errorf("blobs, refs = %d, %d, want 2, 4", blobs, refs)
+	}
+	for _, name := range []string{"/a/1.txt", "/a/2.txt", "/b/3.txt"} {
+		got, err := afero.ReadFile(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("ReadFile(%s) returned wrong content", name)
+		}
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Errorf("Size() = %d, want %d", info.Size(), len(data))
+		}
+	}
+
+	usage, err := fs.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Files != 4 || usage.Size != int64(3*len(data)+5) {
+		t.Errorf("Usage() = %+v", usage)
+	}
+	if usage.SavedSize <= 0 || usage.SavedSize >= usage.CompressedSize {
+		t.Errorf("Usage().SavedSize = %d", usage.SavedSize)
+	}
+
+	if err := fs.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if blobs, refs := blobCount(t, fs); blobs != 2 || refs != 2 {
+		t.Errorf("blobs, refs = %d, %d, want 2, 2", blobs, refs)
+	}
+	if err := fs.Remove("/b/3.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if blobs, refs := blobCount(t, fs); blobs != 1 || refs != 1 {
+		t.Errorf("blobs, refs = %d, %d, want 1, 1", blobs, refs)
+	}
+	usage, err = fs.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.SavedSize != 0 {
+		t.Errorf("Usage().SavedSize = %d, want 0", usage.SavedSize)
+	}
+}
+
+func TestFS_Deduplication_encrypted(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if err := fs.EnableEncryption([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.EnableDeduplication(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/1.txt", "/2.txt"} {
+		if err := afero.WriteFile(fs, name, []byte("test"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if blobs, refs := blobCount(t, fs); blobs != 1 || refs != 2 {
+		t.Errorf("blobs, refs = %d, %d, want 1, 2", blobs, refs)
+	}
+	got, err := afero.ReadFile(fs, "/2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "test" {
+		t.Errorf("ReadFile() = %q, want %q", got, "test")
+	}
+
+	// the hash does not reveal the content
+	plainHash := fmt.Sprintf("%x", sha256.Sum256([]byte("test")))
+	err = sqlitex.Exec(fs.cursor, "SELECT hash FROM sqlar_blobs", func(stmt *sqlite.Stmt) error {
+		if stmt.ColumnText(0) == plainHash {
+			t.Error("blob hash is the SHA-256 of the content")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFS_Deduplication_refs(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if err := fs.EnableDeduplication(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"/1.txt": "one", "/2.txt": "two", "/3.txt": "two"} {
+		if err := afero.WriteFile(fs, name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the file rows hold no data, only the references point to the blobs
+	err = sqlitex.Exec(fs.cursor, "SELECT count(*) FROM sqlar WHERE length(data) > 0", func(stmt *sqlite.Stmt) error {
+		if stmt.ColumnInt64(0) != 0 {
+			t.Errorf("%d files hold data", stmt.ColumnInt64(0))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Rename("/3.txt", "/4.txt"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := afero.ReadFile(fs, "/4.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "two" {
+		t.Errorf("ReadFile() = %q, want %q", got, "two")
+	}
+
+	// blobs that do not match their hash are detected
+	stmt := fs.cursor.Prep("UPDATE sqlar_blobs SET data = (SELECT data FROM sqlar_blobs WHERE refs = 1) WHERE refs = 2")
+	if err := exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadFile(fs, "/2.txt"); err == nil {
+		t.Error("ReadFile() of a modified blob succeeded")
+	}
+}
+
+func TestFS_Deduplication_closeTwice(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if err := fs.EnableDeduplication(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+
+	if blobs, refs := blobCount(t, fs); blobs != 1 || refs != 1 {
+		t.Errorf("blobCount() = %d, %d, want 1, 1", blobs, refs)
+	}
+	got, err := afero.ReadFile(fs, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "foo" {
+		t.Errorf("ReadFile() got %s, want foo", got)
+	}
+}
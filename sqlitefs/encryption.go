@@ -0,0 +1,446 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package sqlitefs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+
+	"crawshaw.io/sqlite"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/forensicanalysis/forensicstore/sqlitefs/spooled"
+)
+
+// Encrypted file contents start with encryptionMagic followed by a random
+// nonce prefix and a sequence of chunks. Every chunk consists of a flag byte,
+// the big endian length of the ciphertext and the AES-256-GCM sealed
+// compressed data. The flag marks the final chunk and is authenticated
+// together with the name of the file, so truncated files and files that were
+// moved to another name are detected.
+const (
+	encryptionMagic  = "FSE1"
+	encryptionChunk  = 64 * 1024
+	noncePrefixSize  = 8
+	keySize          = 32
+	saltSize         = 16
+	kdfIterations    = 100000
+	finalChunk       = 1
+	encryptionVerify = "sqlitefs key verification"
+	macInfo          = "sqlitefs deduplication"
+)
+
+// ErrLocked is returned if encrypted files are accessed without a key.
+var ErrLocked = errors.New("file system is encrypted, a key is required")
+
+// ErrWrongKey is returned by Unlock if the key does not match.
+var ErrWrongKey = errors.New("wrong key")
+
+// ErrAlreadyEncrypted is returned by EnableEncryption if the file system is
+// already encrypted.
+var ErrAlreadyEncrypted = errors.New("file system is already encrypted")
+
+// ErrNotEmpty is returned by EnableEncryption if the file system already
+// contains files.
+var ErrNotEmpty = errors.New("file system is not empty, encryption must be enabled before files are written")
+
+// ErrNotEncrypted is returned by Unlock if the file system is not encrypted,
+// so files would be written in plain text.
+var ErrNotEncrypted = errors.New("file system is not encrypted")
+
+// errFileNotEncrypted is returned if a file of an encrypted file system is
+// stored in plain text.
+var errFileNotEncrypted = errors.New("file is not encrypted")
+
+// errTrailingData is returned if an encrypted file has data after the final
+// chunk.
+var errTrailingData = errors.New("message authentication failed: data after the final chunk")
+
+const encryptionTable = `CREATE TABLE IF NOT EXISTS sqlar_encryption(
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  algorithm TEXT NOT NULL,  -- cipher of the file contents
+  kdf TEXT NOT NULL,        -- key derivation function
+  salt BLOB NOT NULL,       -- salt of the key derivation
+  iterations INT NOT NULL,  -- iterations of the key derivation
+  verifier BLOB NOT NULL    -- sealed known value to check the key
+);`
+
+// Encrypted returns whether the file contents are encrypted.
+func (fs *FS) Encrypted() bool {
+	return fs.encryption.encrypted
+}
+
+// EnableEncryption encrypts all files with a key derived from secret, e.g. a
+// passphrase or the content of a key file. Encryption must be enabled before
+// any file is written, so all files of an encrypted file system are
+// encrypted.
+func (fs *FS) EnableEncryption(secret []byte) error {
+	if fs.encryption.encrypted {
+		return ErrAlreadyEncrypted
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, macKey, err := newKeys(pbkdf2.Key(secret, salt, kdfIterations, keySize, sha256.New))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	verifier := aead.Seal(nonce, nonce, []byte(encryptionVerify), nil)
+
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	stmt := conn.Prep("SELECT count(*) AS count FROM sqlar WHERE data IS NOT NULL")
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	files := stmt.GetInt64("count")
+	if err := stmt.Reset(); err != nil {
+		return err
+	}
+	if files > 0 {
+		return ErrNotEmpty
+	}
+
+	if err := exec(conn.Prep(encryptionTable)); err != nil {
+		return err
+	}
+	stmt = conn.Prep(`INSERT INTO sqlar_encryption (id, algorithm, kdf, salt, iterations, verifier)
+		VALUES (1, 'aes-256-gcm', 'pbkdf2-sha256', $salt, $iterations, $verifier)`)
+	stmt.SetBytes("$salt", salt)
+	stmt.SetInt64("$iterations", kdfIterations)
+	stmt.SetBytes("$verifier", verifier)
+	if err := exec(stmt); err != nil {
+		return err
+	}
+
+	fs.encryption.encrypted = true
+	fs.encryption.aead = aead
+	fs.encryption.macKey = macKey
+	return nil
+}
+
+// Unlock derives the key from secret, so encrypted files can be read and
+// written. It returns ErrNotEncrypted if the file system is not encrypted.
+func (fs *FS) Unlock(secret []byte) error {
+	if !fs.encryption.encrypted {
+		return ErrNotEncrypted
+	}
+
+	conn, release, err := fs.acquire(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	stmt := conn.Prep("SELECT salt, iterations, verifier FROM sqlar_encryption WHERE id = 1")
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	salt := columnBytes(stmt, "salt")
+	iterations := int(stmt.GetInt64("iterations"))
+	verifier := columnBytes(stmt, "verifier")
+	if err := stmt.Reset(); err != nil {
+		return err
+	}
+
+	aead, macKey, err := newKeys(pbkdf2.Key(secret, salt, iterations, keySize, sha256.New))
+	if err != nil {
+		return err
+	}
+	if len(verifier) < aead.NonceSize() {
+		return ErrWrongKey
+	}
+	plain, err := aead.Open(nil, verifier[:aead.NonceSize()], verifier[aead.NonceSize():], nil)
+	if err != nil || string(plain) != encryptionVerify {
+		return ErrWrongKey
+	}
+
+	fs.encryption.aead = aead
+	fs.encryption.macKey = macKey
+	return nil
+}
+
+// encryption is shared by all file systems of a database. The macKey
+// identifies the content of deduplicated files.
+type encryption struct {
+	encrypted bool
+	aead      cipher.AEAD
+	macKey    []byte
+}
+
+func (e *encryption) locked() bool {
+	return e != nil && e.encrypted && e.aead == nil
+}
+
+// setupEncryption reads the encryption mode of the database.
+func (fs *FS) setupEncryption(conn *sqlite.Conn) error {
+	fs.encryption = &encryption{}
+	stmt := conn.Prep("SELECT count(*) AS count FROM sqlite_master WHERE type = 'table' AND name = 'sqlar_encryption'")
+	if _, err := stmt.Step(); err != nil {
+		return err
+	}
+	fs.encryption.encrypted = stmt.GetInt64("count") > 0
+	return stmt.Reset()
+}
+
+// newKeys returns the cipher of the file contents and the HMAC key of the
+// content hashes. Both are derived from key.
+func newKeys(key []byte) (cipher.AEAD, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	macKey := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, key, []byte(macInfo)), macKey); err != nil {
+		return nil, nil, err
+	}
+	return aead, macKey, nil
+}
+
+func columnBytes(stmt *sqlite.Stmt, name string) []byte {
+	b := make([]byte, stmt.GetLen(name))
+	stmt.GetBytes(name, b)
+	return b
+}
+
+// associatedData authenticates the flag of a chunk and the name of the file.
+func associatedData(flag byte, name string) []byte {
+	return append([]byte{flag}, name...)
+}
+
+// reencrypt seals the content of a file again for a new name, as the name is
+// authenticated with the content.
+func (fs *FS) reencrypt(conn *sqlite.Conn, id int64, oldname, newname string) (err error) {
+	if fs.encryption.aead == nil {
+		return ErrLocked
+	}
+
+	blob, err := conn.OpenBlob("", "sqlar", "data", id, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := blob.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(blob, magic); err != nil || string(magic) != encryptionMagic {
+		return errFileNotEncrypted
+	}
+	reader, err := newDecryptReader(blob, fs.encryption.aead, oldname)
+	if err != nil {
+		return err
+	}
+
+	buf, teardown := spooled.New(MaxMemoryBackedSize)
+	defer func() {
+		if err := teardown(); err != nil {
+			log.Println(err)
+		}
+	}()
+	writer, err := newEncryptWriter(buf, fs.encryption.aead, newname)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	size, err := buf.Size()
+	if err != nil {
+		return err
+	}
+
+	stmt := conn.Prep("UPDATE sqlar SET data = $data WHERE rowid = $id")
+	stmt.SetZeroBlob("$data", size)
+	stmt.SetInt64("$id", id)
+	if err := exec(stmt); err != nil {
+		return err
+	}
+	data, err := conn.OpenBlob("", "sqlar", "data", id, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := data.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+	_, err = io.Copy(data, buf)
+	return err
+}
+
+// encryptWriter seals the written data in chunks.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	name    string
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, aead cipher.AEAD, name string) (*encryptWriter, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce[:noncePrefixSize]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(encryptionMagic)); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(nonce[:noncePrefixSize]); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, name: name, nonce: nonce, buf: make([]byte, 0, encryptionChunk)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(e.buf) == encryptionChunk {
+			if err := e.seal(0); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):encryptionChunk], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close writes the final chunk.
+func (e *encryptWriter) Close() error {
+	return e.seal(finalChunk)
+}
+
+func (e *encryptWriter) seal(flag byte) error {
+	binary.BigEndian.PutUint32(e.nonce[noncePrefixSize:], e.counter)
+	e.counter++
+	sealed := e.aead.Seal(nil, e.nonce, e.buf, associatedData(flag, e.name))
+	e.buf = e.buf[:0]
+
+	header := make([]byte, 5)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader opens the chunks written by encryptWriter.
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	name    string
+	nonce   []byte
+	counter uint32
+	buf     *bytes.Reader
+	final   bool
+}
+
+// newDecryptReader reads the nonce prefix, the magic must already be read.
+func newDecryptReader(r io.Reader, aead cipher.AEAD, name string) (*decryptReader, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce[:noncePrefixSize]); err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, name: name, nonce: nonce, buf: bytes.NewReader(nil)}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	return d.buf.Read(p)
+}
+
+func (d *decryptReader) open() error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > encryptionChunk+uint32(d.aead.Overhead()) {
+		return errors.New("invalid encrypted chunk")
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(d.nonce[noncePrefixSize:], d.counter)
+	d.counter++
+	plain, err := d.aead.Open(nil, d.nonce, sealed, associatedData(header[0], d.name))
+	if err != nil {
+		return err
+	}
+	d.final = header[0] == finalChunk
+	if d.final {
+		// data appended after the final chunk is not authenticated
+		_, err := io.ReadFull(d.r, make([]byte, 1))
+		if err == nil {
+			return errTrailingData
+		}
+		if err != io.EOF {
+			return err
+		}
+	}
+	d.buf = bytes.NewReader(plain)
+	return nil
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package sqlitefs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFS_Encryption(t *testing.T) {
+	large := make([]byte, 3*encryptionChunk+17)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"small", []byte("test")},
+		{"multiple chunks", large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := setup(t)
+			defer cleanup(t, tempDir)
+			db := filepath.Join(tempDir, "test.db")
+
+			fs, err := New(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.EnableEncryption([]byte("secret")); err != nil {
+				t.Fatal(err)
+			}
+			if !fs.Encrypted() {
+				t.Fatal("Encrypted() = false")
+			}
+			if err := afero.WriteFile(fs, "/file.bin", tt.data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fs, err = New(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+
+			if _, err := afero.ReadFile(fs, "/file.bin"); !errors.Is(err, ErrLocked) {
+				t.Errorf("ReadFile() error = %v, want %v", err, ErrLocked)
+			}
+			if err := afero.WriteFile(fs, "/other.bin", tt.data, 0666); !errors.Is(err, ErrLocked) {
+				t.Errorf("WriteFile() error = %v, want %v", err, ErrLocked)
+			}
+			if err := fs.Unlock([]byte("wrong")); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("Unlock() error = %v, want %v", err, ErrWrongKey)
+			}
+			if err := fs.Unlock([]byte("secret")); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := afero.ReadFile(fs, "/file.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("ReadFile() got %d bytes, want %d bytes", len(got), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestFS_EncryptionTampered(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if err := fs.EnableEncryption([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*encryptionChunk)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/file.bin", data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the final chunk
+	stmt := fs.cursor.Prep("UPDATE sqlar SET data = substr(data, 1, length(data) - 40) WHERE name = '/file.bin'")
+	if err := exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadFile(fs, "/file.bin"); err == nil {
+		t.Error("ReadFile() of truncated file succeeded")
+	}
+
+	// append data after the final chunk
+	if err := afero.WriteFile(fs, "/appended.bin", data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	stmt = fs.cursor.Prep("UPDATE sqlar SET data = CAST(data || x'00' AS BLOB) WHERE name = '/appended.bin'")
+	if err := exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadFile(fs, "/appended.bin"); !errors.Is(err, errTrailingData) {
+		t.Errorf("ReadFile() error = %v, want %v", err, errTrailingData)
+	}
+}
+
+func TestFS_UnlockNotEncrypted(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if err := fs.Unlock([]byte("secret")); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Unlock() error = %v, want %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestFS_EncryptionName(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if err := fs.EnableEncryption([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/a.txt", "/b.txt"} {
+		if err := afero.WriteFile(fs, name, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// renamed files are encrypted for their new name
+	if err := fs.Rename("/a.txt", "/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := afero.ReadFile(fs, "/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "/a.txt" {
+		t.Errorf("ReadFile() got %s, want /a.txt", got)
+	}
+
+	// contents moved to another file are detected
+	stmt := fs.cursor.Prep("UPDATE sqlar SET data = (SELECT data FROM sqlar WHERE name = '/c.txt') WHERE name = '/b.txt'")
+	if err := exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadFile(fs, "/b.txt"); err == nil {
+		t.Error("ReadFile() of moved content succeeded")
+	}
+
+	// plain text files are not read
+	stmt = fs.cursor.Prep("INSERT INTO sqlar (name, mode, mtime, sz, data) VALUES ('/plain.txt', 438, 0, 5, 'plain')")
+	if err := exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := afero.ReadFile(fs, "/plain.txt"); !errors.Is(err, errFileNotEncrypted) {
+		t.Errorf("ReadFile() error = %v, want %v", err, errFileNotEncrypted)
+	}
+}
+
+func TestFS_EnableEncryptionNotEmpty(t *testing.T) {
+	tempDir := setup(t)
+	defer cleanup(t, tempDir)
+
+	fs, err := New(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if err := afero.WriteFile(fs, "/file.txt", []byte("test"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.EnableEncryption([]byte("secret")); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("EnableEncryption() error = %v, want %v", err, ErrNotEmpty)
+	}
+	if fs.Encrypted() {
+		t.Error("Encrypted() = true")
+	}
+}
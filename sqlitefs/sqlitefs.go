@@ -22,22 +22,34 @@
 package sqlitefs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
 	"github.com/spf13/afero"
 )
 
 type FS struct {
 	cursor      *sqlite.Conn
 	closeCursor bool
+
+	// pooled mode
+	pool      *sqlitex.Pool
+	writeLock sync.Locker
+
+	encryption *encryption
+	dedup      *deduplication
 }
 
+var errPoolClosed = errors.New("connection pool closed")
+
 const table = `CREATE TABLE IF NOT EXISTS sqlar(
   name TEXT PRIMARY KEY,  -- name of the file
   mode INT,               -- access permissions
@@ -57,29 +69,106 @@ func New(url string) (*FS, error) {
 
 	stmt := fs.cursor.Prep(table)
 	err = exec(stmt)
+	if err != nil {
+		return nil, err
+	}
 
-	return fs, err
+	if err := fs.setupEncryption(fs.cursor); err != nil {
+		return nil, err
+	}
+	return fs, fs.setupDeduplication(fs.cursor)
 }
 
 func NewCursor(conn *sqlite.Conn) (*FS, error) {
 	fs := &FS{cursor: conn, closeCursor: false}
 	stmt := fs.cursor.Prep(table)
 	err := exec(stmt)
+	if err != nil {
+		return nil, err
+	}
 
-	return fs, err
+	if err := fs.setupEncryption(fs.cursor); err != nil {
+		return nil, err
+	}
+	return fs, fs.setupDeduplication(fs.cursor)
+}
+
+// NewPool creates a FS that takes a connection from the pool for every
+// operation, so it can be used by multiple goroutines. Writes are serialized
+// with writeLock, which can be shared with other writers of the database.
+func NewPool(pool *sqlitex.Pool, writeLock sync.Locker) (*FS, error) {
+	fs := &FS{pool: pool, writeLock: writeLock}
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	stmt := conn.Prep(table)
+	err = exec(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.setupEncryption(conn); err != nil {
+		return nil, err
+	}
+	return fs, fs.setupDeduplication(conn)
+}
+
+// Bind returns a FS that uses only conn and shares the encryption key and the
+// deduplication mode with fs.
+func (fs *FS) Bind(conn *sqlite.Conn) *FS {
+	return &FS{cursor: conn, closeCursor: false, encryption: fs.encryption, dedup: fs.dedup}
+}
+
+// acquire returns a connection and a function to release it.
+func (fs *FS) acquire(write bool) (*sqlite.Conn, func(), error) {
+	if fs.pool == nil {
+		return fs.cursor, func() {}, nil
+	}
+
+	if write {
+		fs.writeLock.Lock()
+	}
+	conn := fs.pool.Get(nil)
+	if conn == nil {
+		if write {
+			fs.writeLock.Unlock()
+		}
+		return nil, nil, errPoolClosed
+	}
+	return conn, func() {
+		fs.pool.Put(conn)
+		if write {
+			fs.writeLock.Unlock()
+		}
+	}, nil
 }
 
 func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	name = normalizeFilename(name)
-	stmt := fs.cursor.Prep("UPDATE sqlar SET mode = $mode WHERE name = $name")
+	stmt := conn.Prep("UPDATE sqlar SET mode = $mode WHERE name = $name")
 	stmt.SetText("$name", name)
 	stmt.SetInt64("$mode", int64(mode))
 	return exec(stmt)
 }
 
 func (fs *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	name = normalizeFilename(name)
-	stmt := fs.cursor.Prep("UPDATE sqlar SET mtime = $mtime WHERE name = $name")
+	stmt := conn.Prep("UPDATE sqlar SET mtime = $mtime WHERE name = $name")
 	stmt.SetText("$name", name)
 	stmt.SetInt64("$mtime", mtime.Unix())
 	return exec(stmt)
@@ -90,9 +179,15 @@ func (fs *FS) Create(name string) (afero.File, error) {
 }
 
 func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	name = normalizeFilename(name)
 
-	stmt := fs.cursor.Prep(`INSERT INTO sqlar (name, mode, mtime, sz, data) VALUES ($name, $mode, $mtime, $sz, $data)`)
+	stmt := conn.Prep(`INSERT INTO sqlar (name, mode, mtime, sz, data) VALUES ($name, $mode, $mtime, $sz, $data)`)
 
 	stmt.SetText("$name", name)
 	stmt.SetInt64("$mode", int64(perm))
@@ -129,19 +224,30 @@ func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (afero.File, err
 	var id int64
 	var err error
 	if flag&os.O_CREATE != 0 {
+		if fs.encryption.locked() {
+			return nil, ErrLocked
+		}
+
 		id, err = fs.createFile(name, perm)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		stmt := fs.cursor.Prep(`SELECT rowid, mode, mtime, sz, CASE WHEN data IS NULL THEN 'TRUE' ELSE 'FALSE' END dataNull FROM sqlar WHERE name = $name`)
+		conn, release, err := fs.acquire(false)
+		if err != nil {
+			return nil, err
+		}
+
+		stmt := conn.Prep(`SELECT rowid, mode, mtime, sz, CASE WHEN data IS NULL THEN 'TRUE' ELSE 'FALSE' END dataNull FROM sqlar WHERE name = $name`)
 
 		stmt.SetText("$name", name)
 
 		hasRow, err := stmt.Step()
 		if err != nil {
+			release()
 			return nil, err
 		} else if !hasRow {
+			release()
 			return nil, os.ErrNotExist // afero.Exists needs os.ErrNotExists
 		}
 
@@ -158,29 +264,38 @@ func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (afero.File, err
 
 		err = stmt.Reset()
 		if err != nil {
+			release()
 			return nil, err
 		}
 
 		// directory
 		var children []os.FileInfo
 		if info.dir {
-			children, err = fs.selectChildren(name, children)
+			children, err = selectChildren(conn, name, children)
+			release()
 			if err != nil {
 				return nil, err
 			}
+			return newReadItem(nil, func() {}, id, name, info, children, nil)
 		}
 
-		return newReadItem(fs, id, name, info, children)
+		// the connection is released when the item is closed
+		i, err := newReadItem(conn, release, id, name, info, children, fs)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return i, nil
 	}
 
 	if flag&os.O_RDWR != 0 || flag&os.O_WRONLY != 0 {
-		return newWriteItem(fs, id, name), nil
+		return newWriteItem(fs, id, name)
 	}
 	return nil, ErrNotImplemented
 }
 
-func (fs *FS) selectChildren(name string, children []os.FileInfo) ([]os.FileInfo, error) {
-	stmt := fs.cursor.Prep(`SELECT name, mode, mtime, sz, CASE WHEN data IS NULL THEN 'TRUE' ELSE 'FALSE' END dataNull FROM sqlar WHERE name LIKE $name`)
+func selectChildren(conn *sqlite.Conn, name string, children []os.FileInfo) ([]os.FileInfo, error) {
+	stmt := conn.Prep(`SELECT name, mode, mtime, sz, CASE WHEN data IS NULL THEN 'TRUE' ELSE 'FALSE' END dataNull FROM sqlar WHERE name LIKE $name`)
 	if name == "/" {
 		stmt.SetText("$name", "/%")
 	} else {
@@ -213,48 +328,115 @@ func (fs *FS) selectChildren(name string, children []os.FileInfo) ([]os.FileInfo
 }
 
 func (fs *FS) createFile(name string, perm os.FileMode) (int64, error) {
-	stmt := fs.cursor.Prep(`INSERT INTO sqlar (name, mode, mtime, sz) VALUES ($name, $mode, $mtime, $sz)`)
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	stmt := conn.Prep(`INSERT INTO sqlar (name, mode, mtime, sz) VALUES ($name, $mode, $mtime, $sz)`)
 
 	stmt.SetText("$name", name)
 	stmt.SetInt64("$mode", int64(perm))
 	stmt.SetInt64("$mtime", time.Now().Unix())
 	stmt.SetInt64("$sz", 0)
 
-	err := exec(stmt)
+	err = exec(stmt)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create %s: %w", name, err)
 	}
-	return fs.cursor.LastInsertRowID(), nil
+	return conn.LastInsertRowID(), nil
 }
 
-func (fs *FS) Remove(name string) error {
+func (fs *FS) Remove(name string) (err error) {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer sqlitex.Save(conn)(&err)
+
 	name = normalizeFilename(name)
-	stmt := fs.cursor.Prep(`DELETE FROM sqlar WHERE name = $name`)
+	if err := fs.releaseBlobs(conn, "name = $name", name); err != nil {
+		return err
+	}
+	stmt := conn.Prep(`DELETE FROM sqlar WHERE name = $name`)
 	stmt.SetText("$name", name)
 	return exec(stmt)
 }
 
-func (fs *FS) RemoveAll(path string) error {
+func (fs *FS) RemoveAll(path string) (err error) {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer sqlitex.Save(conn)(&err)
+
 	path = normalizeFilename(path)
-	stmt := fs.cursor.Prep(`DELETE FROM sqlar WHERE name LIKE $name`)
+	if err := fs.releaseBlobs(conn, "name LIKE $name", path+"%"); err != nil {
+		return err
+	}
+	stmt := conn.Prep(`DELETE FROM sqlar WHERE name LIKE $name`)
 	stmt.SetText("$name", path+"%")
 	return exec(stmt)
 }
 
-func (fs *FS) Rename(oldname, newname string) error {
+// Rename renames a file. The content of encrypted files is encrypted again for
+// the new name.
+func (fs *FS) Rename(oldname, newname string) (err error) {
+	conn, release, err := fs.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer sqlitex.Save(conn)(&err)
+
 	oldname = normalizeFilename(oldname)
 	newname = normalizeFilename(newname)
 
-	stmt := fs.cursor.Prep("UPDATE sqlar SET name = $newname WHERE name = $oldname")
+	blobHash, _, err := fs.blobRef(conn, oldname)
+	if err != nil {
+		return err
+	}
+	if blobHash != "" {
+		if err := fs.renameRef(conn, oldname, newname); err != nil {
+			return err
+		}
+	} else if fs.encryption.encrypted {
+		stmt := conn.Prep("SELECT rowid FROM sqlar WHERE name = $name AND data IS NOT NULL")
+		stmt.SetText("$name", oldname)
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return err
+		}
+		id := stmt.GetInt64("rowid")
+		if err := stmt.Reset(); err != nil {
+			return err
+		}
+		if hasRow {
+			if err := fs.reencrypt(conn, id, oldname, newname); err != nil {
+				return err
+			}
+		}
+	}
+
+	stmt := conn.Prep("UPDATE sqlar SET name = $newname WHERE name = $oldname")
 	stmt.SetText("$oldname", oldname)
 	stmt.SetText("$newname", newname)
 	return exec(stmt)
 }
 
 func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	conn, release, err := fs.acquire(false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	name = normalizeFilename(name)
 
-	stmt := fs.cursor.Prep("SELECT name, mode, mtime, sz, CASE WHEN data IS NULL THEN 'TRUE' ELSE 'FALSE' END dataNull FROM sqlar WHERE name = $name")
+	stmt := conn.Prep("SELECT name, mode, mtime, sz, CASE WHEN data IS NULL THEN 'TRUE' ELSE 'FALSE' END dataNull FROM sqlar WHERE name = $name")
 
 	stmt.SetText("$name", name)
 
@@ -279,7 +461,7 @@ func (fs *FS) Stat(name string) (os.FileInfo, error) {
 }
 
 func (fs *FS) Close() error {
-	if fs.closeCursor {
+	if fs.closeCursor && fs.cursor != nil {
 		return fs.cursor.Close()
 	}
 	return nil
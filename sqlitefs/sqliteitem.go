@@ -26,11 +26,14 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"errors"
+	"hash"
 	"io"
 	"log"
 	"os"
 	"path"
 
+	"crawshaw.io/sqlite"
+
 	"github.com/forensicanalysis/forensicstore/sqlitefs/spooled"
 )
 
@@ -47,47 +50,111 @@ type item struct {
 	children     []os.FileInfo
 	uncompressor io.Reader
 	blob         io.ReadCloser
+	release      func()
 
 	// writer item
 	id          int64
 	size        int64
 	compressor  io.Writer
+	encrypter   io.WriteCloser
+	hash        hash.Hash
 	writeBuffer *spooled.TemporaryFile
 	teardown    func() error
+
+	// closed is set by the first Close, further calls do nothing
+	closed bool
 }
 
-func newWriteItem(fs *FS, id int64, path string) *item {
+// newWriteItem creates an item to write a file. The data is compressed and,
+// if the file system is unlocked, encrypted afterwards. The content of
+// deduplicated file systems is hashed as well. Deduplicated contents are shared
+// by multiple files, so they are not encrypted for the name of a file.
+func newWriteItem(fs *FS, id int64, path string) (*item, error) {
 	buf, teardown := spooled.New(MaxMemoryBackedSize)
 	i := &item{fs: fs, id: id, path: path, writeBuffer: buf, teardown: teardown}
+	if fs.dedup != nil && fs.dedup.enabled {
+		i.hash = fs.contentHash()
+	}
+	if fs.encryption != nil && fs.encryption.aead != nil {
+		name := path
+		if i.hash != nil {
+			name = ""
+		}
+		encrypter, err := newEncryptWriter(i.writeBuffer, fs.encryption.aead, name)
+		if err != nil {
+			return nil, err
+		}
+		i.encrypter = encrypter
+		i.compressor = gzip.NewWriter(encrypter)
+		return i, nil
+	}
 	i.compressor = gzip.NewWriter(i.writeBuffer)
-	return i
+	return i, nil
 }
 
-func newReadItem(fs *FS, id int64, path string, info os.FileInfo, children []os.FileInfo) (i *item, err error) {
-	i = &item{fs: fs, path: path, info: info, children: children}
+// newReadItem creates an item to read a file or directory. The connection is
+// only used for files, release is called when the item is closed. All files of
+// encrypted file systems are decrypted, deduplicated files are read from their
+// blob and verified against its hash.
+func newReadItem(conn *sqlite.Conn, release func(), id int64, path string, info os.FileInfo, children []os.FileInfo, fs *FS) (i *item, err error) { // nolint:lll
+	i = &item{path: path, info: info, children: children, release: release}
 
 	if !info.IsDir() {
-		i.blob, err = i.fs.cursor.OpenBlob("", "sqlar", "data", id, false)
+		blobHash, blobID, err := fs.blobRef(conn, path)
 		if err != nil {
 			return nil, err
 		}
 
+		// deduplicated contents are not encrypted for a name
+		name := path
+		if blobHash != "" {
+			name = ""
+			i.blob, err = conn.OpenBlob("", "sqlar_blobs", "data", blobID, false)
+		} else {
+			i.blob, err = conn.OpenBlob("", "sqlar", "data", id, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reader := io.Reader(i.blob)
+		if fs != nil && fs.encryption != nil && fs.encryption.encrypted {
+			if fs.encryption.aead == nil {
+				return nil, ErrLocked
+			}
+			magic := make([]byte, len(encryptionMagic))
+			if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != encryptionMagic {
+				return nil, errFileNotEncrypted
+			}
+			reader, err = newDecryptReader(reader, fs.encryption.aead, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		b := make([]byte, 2)
-		_, err = i.blob.Read(b)
+		_, err = io.ReadFull(reader, b)
 		if err != nil {
 			return nil, err
 		}
 
-		patchedReader := io.MultiReader(bytes.NewReader(b), i.blob)
+		patchedReader := io.MultiReader(bytes.NewReader(b), reader)
 
 		if b[0] == 0x1f && b[1] == 0x8b {
 			i.uncompressor, err = gzip.NewReader(patchedReader)
 		} else {
 			i.uncompressor = flate.NewReader(patchedReader)
 		}
+		if err != nil {
+			return nil, err
+		}
+
+		if blobHash != "" {
+			i.uncompressor = &verifyReader{Reader: i.uncompressor, hash: fs.contentHash(), want: blobHash}
+		}
 	}
 
-	return i, err
+	return i, nil
 }
 
 func (i *item) Name() string {
@@ -131,6 +198,9 @@ func (i *item) Stat() (os.FileInfo, error) {
 
 func (i *item) Write(p []byte) (n int, err error) {
 	i.size += int64(len(p))
+	if i.hash != nil {
+		i.hash.Write(p)
+	}
 	return i.compressor.Write(p)
 }
 
@@ -143,6 +213,18 @@ func (i *item) WriteString(s string) (ret int, err error) {
 }
 
 func (i *item) Close() error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+
+	if i.release != nil {
+		defer func() {
+			i.release()
+			i.release = nil
+		}()
+	}
+
 	if i.uncompressor != nil && i.blob != nil {
 		if closer, ok := i.uncompressor.(io.Closer); ok {
 			err := closer.Close()
@@ -158,14 +240,36 @@ func (i *item) Close() error {
 				return err
 			}
 		}
+		if i.encrypter != nil {
+			err := i.encrypter.Close()
+			if err != nil {
+				return err
+			}
+		}
 
-		stmt := i.fs.cursor.Prep(`UPDATE sqlar SET sz = $sz, data = $data WHERE name = $name`)
+		conn, release, err := i.fs.acquire(true)
+		if err != nil {
+			return err
+		}
+		defer release()
 
 		size, err := i.writeBuffer.Size()
 		if err != nil {
 			return err
 		}
 
+		if i.hash != nil {
+			defer func() {
+				err := i.teardown()
+				if err != nil {
+					log.Println(err)
+				}
+			}()
+			return i.storeDeduplicated(conn, size)
+		}
+
+		stmt := conn.Prep(`UPDATE sqlar SET sz = $sz, data = $data WHERE name = $name`)
+
 		stmt.SetText("$name", i.path)
 		stmt.SetZeroBlob("$data", size)
 		stmt.SetInt64("$sz", i.size)
@@ -180,7 +284,7 @@ func (i *item) Close() error {
 			return err
 		}
 
-		data, err := i.fs.cursor.OpenBlob("", "sqlar", "data", i.id, true)
+		data, err := conn.OpenBlob("", "sqlar", "data", i.id, true)
 		if err != nil {
 			return err
 		}
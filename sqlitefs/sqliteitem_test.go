@@ -28,12 +28,15 @@ import (
 	"reflect"
 	"testing"
 
+	"crawshaw.io/sqlite"
+
 	"github.com/forensicanalysis/forensicstore/sqlitefs/spooled"
 )
 
 func TestNewReadItem(t *testing.T) {
 	type args struct {
-		fs       *FS
+		conn     *sqlite.Conn
+		release  func()
 		id       int64
 		path     string
 		info     os.FileInfo
@@ -49,7 +52,7 @@ func TestNewReadItem(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := newReadItem(tt.args.fs, tt.args.id, tt.args.path, tt.args.info, tt.args.children)
+			got, err := newReadItem(tt.args.conn, tt.args.release, tt.args.id, tt.args.path, tt.args.info, tt.args.children, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("newReadItem() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -76,7 +79,10 @@ func TestNewWriteItem(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := newWriteItem(tt.args.fs, tt.args.id, tt.args.path)
+			got, err := newWriteItem(tt.args.fs, tt.args.id, tt.args.path)
+			if err != nil {
+				t.Fatal(err)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("newWriteItem() got = %v, want %v", got, tt.want)
 			}
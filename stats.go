@@ -0,0 +1,159 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/spf13/afero"
+
+	"github.com/forensicanalysis/forensicstore/sqlitefs"
+)
+
+// Store formats reported by Stats.
+const (
+	FormatSQLite = "sqlite"
+	FormatDirFS  = "dirfs"
+)
+
+// Stats summarizes the content of a store.
+type Stats struct {
+	Version          int64            `json:"version"`
+	ApplicationID    int64            `json:"application_id"`
+	Format           string           `json:"format"`
+	Elements         int64            `json:"elements"`
+	Types            map[string]int64 `json:"types"`
+	Files            int64            `json:"files"`
+	CompressedSize   int64            `json:"compressed_size"`
+	UncompressedSize int64            `json:"uncompressed_size"`
+	SavedSize        int64            `json:"saved_size"`
+	FirstInsert      *time.Time       `json:"first_insert,omitempty"`
+	LastInsert       *time.Time       `json:"last_insert,omitempty"`
+}
+
+// Stats returns a summary of the store.
+func (store *ForensicStore) Stats() (*Stats, error) {
+	return store.StatsContext(context.Background())
+}
+
+// StatsContext is like Stats, but aborts if ctx is done.
+func (store *ForensicStore) StatsContext(ctx context.Context) (stats *Stats, err error) {
+	stats = &Stats{Types: map[string]int64{}}
+	stats.Version, err = store.pragma("user_version")
+	if err != nil {
+		return nil, err
+	}
+	stats.ApplicationID, err = store.pragma("application_id")
+	if err != nil {
+		return nil, err
+	}
+	stats.Format = FormatSQLite
+	if stats.ApplicationID == elementaryApplicationIDDirFS {
+		stats.Format = FormatDirFS
+	}
+
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release(&err)
+
+	err = sqlitex.Exec(conn, "SELECT json_extract(json, '$.type') AS type, count(*) AS count FROM elements GROUP BY type",
+		func(stmt *sqlite.Stmt) error {
+			stats.Types[stmt.GetText("type")] = stmt.GetInt64("count")
+			stats.Elements += stmt.GetInt64("count")
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 3339 times with fractional seconds are not ordered as text
+	err = sqlitex.Exec(conn, "SELECT "+
+		"(SELECT insert_time FROM elements WHERE julianday(insert_time) IS NOT NULL "+
+		"ORDER BY julianday(insert_time) LIMIT 1) AS first, "+
+		"(SELECT insert_time FROM elements WHERE julianday(insert_time) IS NOT NULL "+
+		"ORDER BY julianday(insert_time) DESC LIMIT 1) AS last",
+		func(stmt *sqlite.Stmt) (err error) {
+			stats.FirstInsert, err = parseInsertTime(stmt.GetText("first"))
+			if err != nil {
+				return err
+			}
+			stats.LastInsert, err = parseInsertTime(stmt.GetText("last"))
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.fileStats(conn, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// fileStats adds the number and the sizes of the stored files to stats. The
+// compressed size does not include the space saved by deduplication.
+func (store *ForensicStore) fileStats(conn *sqlite.Conn, stats *Stats) error {
+	if fs, ok := store.Fs.(*sqlitefs.FS); ok {
+		usage, err := fs.Bind(conn).Usage()
+		if err != nil {
+			return err
+		}
+		stats.Files = usage.Files
+		stats.CompressedSize = usage.CompressedSize
+		stats.UncompressedSize = usage.Size
+		stats.SavedSize = usage.SavedSize
+		return nil
+	}
+
+	err := afero.Walk(store.Fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			stats.Files++
+			stats.CompressedSize += info.Size()
+			stats.UncompressedSize += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func parseInsertTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
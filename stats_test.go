@@ -0,0 +1,158 @@
+// Copyright (c) 2020 Siemens AG
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+//
+// Author(s): Jonas Plum
+
+package forensicstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForensicStore_Stats(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(Version), stats.Version)
+	assert.Equal(t, int64(elementaryApplicationID), stats.ApplicationID)
+	assert.Equal(t, FormatSQLite, stats.Format)
+	assert.Equal(t, int64(7), stats.Elements)
+	assert.Equal(t, map[string]int64{"process": 2, "windows-registry-key": 2, "file": 2, "directory": 1}, stats.Types)
+	assert.Equal(t, int64(5), stats.Files)
+	assert.Equal(t, int64(123), stats.UncompressedSize)
+	assert.Greater(t, stats.CompressedSize, int64(0))
+	if assert.NotNil(t, stats.FirstInsert) && assert.NotNil(t, stats.LastInsert) {
+		assert.False(t, stats.LastInsert.Before(*stats.FirstInsert))
+	}
+
+	// times without fractional seconds sort after times with them as text
+	insertTimes := map[string]string{
+		"":               "2020-01-01T00:00:00.5Z",
+		ProcessElementId: "2020-01-01T00:00:00Z",
+		fooDocID:         "2020-01-01T00:00:01.5Z",
+		amcacheID:        "2020-01-01T00:00:01Z",
+	}
+	for _, id := range []string{"", ProcessElementId, fooDocID, amcacheID} {
+		query := "UPDATE elements SET insert_time = '" + insertTimes[id] + "'"
+		if id != "" {
+			query += " WHERE id = '" + id + "'"
+		}
+		assert.NoError(t, store.exec(query))
+	}
+	stats, err = store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, stats.FirstInsert) && assert.NotNil(t, stats.LastInsert) {
+		assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), stats.FirstInsert.UTC())
+		assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 1, 5e8, time.UTC), stats.LastInsert.UTC())
+	}
+}
+
+func TestForensicStore_StatsDirFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := NewDirFS(filepath.Join(dir, "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, FormatDirFS, stats.Format)
+	assert.Equal(t, int64(0), stats.Elements)
+	assert.Nil(t, stats.FirstInsert)
+
+	assert.NoError(t, store.Fs.MkdirAll("/dir", 0755))
+	assert.NoError(t, afero.WriteFile(store.Fs, "/dir/foo.txt", []byte("foo"), 0644))
+	stats, err = store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), stats.Files)
+	assert.Equal(t, int64(3), stats.UncompressedSize)
+	assert.Equal(t, int64(3), stats.CompressedSize)
+}
+
+func TestForensicStore_StatsDeduplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, teardown, err := New(filepath.Join(dir, "test.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+	assert.NoError(t, store.EnableDeduplication())
+
+	for _, name := range []string{"/foo.txt", "/bar.txt"} {
+		_, w, teardownFile, err := store.StoreFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte("hello world"))
+		assert.NoError(t, err)
+		assert.NoError(t, teardownFile())
+	}
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), stats.Files)
+	assert.Equal(t, int64(22), stats.UncompressedSize)
+	assert.Greater(t, stats.SavedSize, int64(0))
+	assert.Greater(t, stats.CompressedSize, stats.SavedSize)
+
+	b, err := afero.ReadFile(store.Fs, "/bar.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	flaws, err := store.VerifyIntegrity()
+	assert.NoError(t, err)
+	assert.Empty(t, flaws)
+
+	dirStore, dirTeardown, err := NewDirFS(filepath.Join(dir, "dir.forensicstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dirTeardown()
+	assert.Error(t, dirStore.EnableDeduplication())
+}
@@ -25,6 +25,7 @@
 package forensicstore
 
 import (
+	"context"
 	"crypto/md5"  // #nosec
 	"crypto/sha1" // #nosec
 	"crypto/sha256"
@@ -38,9 +39,11 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
 	"github.com/fatih/structs"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -50,7 +53,7 @@ import (
 	"github.com/forensicanalysis/forensicstore/sqlitefs"
 )
 
-const Version = 3
+const Version = 4
 const elementaryApplicationID = 0x656c656d
 const elementaryApplicationIDDirFS = 0x656c7a70
 const discriminator = "type"
@@ -64,29 +67,96 @@ const discriminator = "type"
 type ForensicStore struct {
 	Fs         afero.Fs
 	connection *sqlite.Conn
+	pool       *sqlitex.Pool
+	writeMu    *sync.Mutex
 	types      *typeMap
+	user       string
+	url        string
+	// labelsTable is set if the store has a labels table.
+	labelsTable bool
 }
 
 var ErrStoreExists = fmt.Errorf("store already exists")
 var ErrStoreNotExists = fmt.Errorf("store does not exist")
+var ErrElementNotExists = fmt.Errorf("element does not exist")
+var ErrKeyRequired = fmt.Errorf("store is encrypted, a key is required")
+var ErrNotEncrypted = fmt.Errorf("store is not encrypted")
+
+// BatchError is returned if an element of a batch could not be inserted.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("element %d: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+const elementsTable = "CREATE TABLE \"elements\" (" +
+	"\"id\" TEXT NOT NULL," +
+	"\"json\" TEXT," +
+	"\"insert_time\" TEXT," +
+	"PRIMARY KEY(\"id\")" +
+	")"
+
+// elementIndexes are created for new stores and by migrations. Stores of
+// version 3 lack the insert time and relationship indexes.
+var elementIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS type_index ON elements(json_extract(json, '$.type'));",
+	"CREATE INDEX IF NOT EXISTS origin_path_index ON elements(json_extract(json, '$.origin.path'));",
+	"CREATE INDEX IF NOT EXISTS path_index ON elements(json_extract(json, '$.path'));",
+	"CREATE INDEX IF NOT EXISTS key_index ON elements(json_extract(json, '$.key'));",
+	"CREATE INDEX IF NOT EXISTS errors_index ON elements(json_extract(json, '$.errors'));",
+	"CREATE INDEX IF NOT EXISTS label_index ON elements(json_extract(json, '$.labels'));",
+	"CREATE INDEX IF NOT EXISTS artifact_index ON elements(json_extract(json, '$.artifact'));",
+	"CREATE INDEX IF NOT EXISTS insert_time_index ON elements(insert_time, id);",
+	"CREATE INDEX IF NOT EXISTS source_ref_index ON elements(json_extract(json, '$.source_ref'));",
+	"CREATE INDEX IF NOT EXISTS target_ref_index ON elements(json_extract(json, '$.target_ref'));",
+}
+
+const insertQuery = "INSERT INTO `elements` (id, json, insert_time) VALUES ($id, $json, $time)"
 
 // New creates a new Forensicstore.
 func New(url string) (store *ForensicStore, teardown func() error, err error) { // nolint:gocyclo
-	return open(url, true, elementaryApplicationID)
+	return open(url, true, elementaryApplicationID, 0, nil)
+}
+
+// NewEncrypted creates a new Forensicstore that encrypts the contents of all
+// files in Fs with a key derived from secret, e.g. a passphrase or the
+// content of a key file.
+func NewEncrypted(url string, secret []byte) (store *ForensicStore, teardown func() error, err error) {
+	return open(url, true, elementaryApplicationID, 0, secret)
 }
 
 // New creates a new Forensicstore.
 func NewDirFS(url string) (store *ForensicStore, teardown func() error, err error) { // nolint:gocyclo
-	return open(url, true, elementaryApplicationIDDirFS)
+	return open(url, true, elementaryApplicationIDDirFS, 0, nil)
 }
 
-// Open opens an existing Forensicstore.
+// Open opens an existing Forensicstore. Encrypted stores must be opened with
+// OpenEncrypted.
 func Open(url string) (store *ForensicStore, teardown func() error, err error) { // nolint:gocyclo
-	return open(url, false, -1)
+	return open(url, false, -1, 0, nil)
 }
 
-func (store *ForensicStore) pragma(name string) (int64, error) {
-	stmt, err := store.connection.Prepare("PRAGMA " + name)
+// OpenEncrypted opens an existing Forensicstore and unlocks its encrypted
+// files with secret.
+func OpenEncrypted(url string, secret []byte) (store *ForensicStore, teardown func() error, err error) {
+	return open(url, false, -1, 0, secret)
+}
+
+func (store *ForensicStore) pragma(name string) (i int64, err error) {
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		return 0, err
+	}
+	defer release(&err)
+
+	stmt, err := conn.Prepare("PRAGMA " + name)
 	if err != nil {
 		return 0, err
 	}
@@ -94,12 +164,18 @@ func (store *ForensicStore) pragma(name string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	i := stmt.GetInt64(name)
+	i = stmt.GetInt64(name)
 	return i, stmt.Finalize()
 }
 
-func (store *ForensicStore) setPragma(name string, i int64) error {
-	stmt, err := store.connection.Prepare("PRAGMA " + name + " = " + fmt.Sprint(i))
+func (store *ForensicStore) setPragma(name string, i int64) (err error) {
+	conn, release, err := store.acquire(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	stmt, err := conn.Prepare("PRAGMA " + name + " = " + fmt.Sprint(i))
 	if err != nil {
 		return err
 	}
@@ -110,7 +186,7 @@ func (store *ForensicStore) setPragma(name string, i int64) error {
 	return stmt.Finalize()
 }
 
-func open(storeURL string, create bool, applicationID int64) (store *ForensicStore, teardown func() error, err error) { // nolint:gocyclo,funlen,gocognit,lll
+func open(storeURL string, create bool, applicationID int64, poolSize int, secret []byte) (store *ForensicStore, teardown func() error, err error) { // nolint:gocyclo,funlen,gocognit,lll
 	if storeURL != "file::memory:?mode=memory" {
 		storeURL = strings.TrimRight(storeURL, "/")
 		if !strings.HasSuffix(storeURL, ".forensicstore") {
@@ -147,9 +223,31 @@ func open(storeURL string, create bool, applicationID int64) (store *ForensicSto
 		}
 	}
 
-	store = &ForensicStore{}
+	store = &ForensicStore{user: currentUser(), url: storeURL}
 
-	store.connection, err = sqlite.OpenConn(storeURL, 0)
+	var fs *sqlitefs.FS
+	if poolSize > 0 {
+		store.pool, err = sqlitex.Open(storeURL, 0, poolSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		store.writeMu = &sync.Mutex{}
+		err = store.registerPoolFunctions(poolSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		fs, err = sqlitefs.NewPool(store.pool, store.writeMu)
+	} else {
+		store.connection, err = sqlite.OpenConn(storeURL, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = registerFunctions(store.connection)
+		if err != nil {
+			return nil, nil, err
+		}
+		fs, err = sqlitefs.NewCursor(store.connection)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -161,10 +259,6 @@ func open(storeURL string, create bool, applicationID int64) (store *ForensicSto
 	case elementaryApplicationID:
 		fallthrough
 	default:
-		fs, err := sqlitefs.NewCursor(store.connection)
-		if err != nil {
-			return nil, nil, err
-		}
 		store.Fs = fs
 	}
 
@@ -179,40 +273,17 @@ func open(storeURL string, create bool, applicationID int64) (store *ForensicSto
 			return nil, nil, err
 		}
 
-		err = store.exec("CREATE TABLE \"elements\" (" +
-			"\"id\" TEXT NOT NULL," +
-			"\"json\" TEXT," +
-			"\"insert_time\" TEXT," +
-			"PRIMARY KEY(\"id\")" +
-			")")
+		err = store.exec(elementsTable)
 		if err != nil {
 			return nil, nil, err
 		}
-		err = store.exec("CREATE INDEX type_index ON elements(json_extract(json, '$.type'));")
-		if err != nil {
-			return nil, nil, err
-		}
-		err = store.exec("CREATE INDEX origin_path_index ON elements(json_extract(json, '$.origin.path'));")
-		if err != nil {
-			return nil, nil, err
-		}
-		err = store.exec("CREATE INDEX path_index ON elements(json_extract(json, '$.path'));")
-		if err != nil {
-			return nil, nil, err
-		}
-		err = store.exec("CREATE INDEX key_index ON elements(json_extract(json, '$.key'));")
-		if err != nil {
-			return nil, nil, err
-		}
-		err = store.exec("CREATE INDEX errors_index ON elements(json_extract(json, '$.errors'));")
-		if err != nil {
-			return nil, nil, err
-		}
-		err = store.exec("CREATE INDEX label_index ON elements(json_extract(json, '$.labels'));")
-		if err != nil {
-			return nil, nil, err
+		for _, index := range elementIndexes {
+			err = store.exec(index)
+			if err != nil {
+				return nil, nil, err
+			}
 		}
-		err = store.exec("CREATE INDEX artifact_index ON elements(json_extract(json, '$.artifact'));")
+		err = store.createLabelsTable()
 		if err != nil {
 			return nil, nil, err
 		}
@@ -230,12 +301,29 @@ func open(storeURL string, create bool, applicationID int64) (store *ForensicSto
 		if err != nil {
 			return nil, nil, err
 		}
-		if version != 3 && version != 2 {
-			msg := "wrong file format (user_version is %d, requires 2 or 3)"
-			return nil, nil, fmt.Errorf(msg, version)
+		if version < MinVersion || version > Version {
+			msg := "wrong file format (user_version is %d, requires %d to %d)"
+			return nil, nil, fmt.Errorf(msg, version, MinVersion, Version)
 		}
 	}
 
+	err = setupEncryption(fs, create, applicationID, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if create {
+		err = store.setupAudit()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = store.setupLabels()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	store.types = newTypeMap()
 	err = store.setupTypes()
 	if err != nil {
@@ -247,10 +335,51 @@ func open(storeURL string, create bool, applicationID int64) (store *ForensicSto
 	return store, store.Close, nil
 }
 
+// setupEncryption enables encryption for new stores or unlocks existing
+// stores if a secret is given.
+func setupEncryption(fs *sqlitefs.FS, create bool, applicationID int64, secret []byte) error {
+	if secret == nil {
+		if fs.Encrypted() {
+			return ErrKeyRequired
+		}
+		return nil
+	}
+
+	if create {
+		if applicationID == elementaryApplicationIDDirFS {
+			return errors.New("encryption is not supported for directory stores")
+		}
+		return fs.EnableEncryption(secret)
+	}
+	if !fs.Encrypted() {
+		return ErrNotEncrypted
+	}
+	return fs.Unlock(secret)
+}
+
+// Encrypted returns whether the files of the store are encrypted.
+func (store *ForensicStore) Encrypted() bool {
+	fs, ok := store.Fs.(*sqlitefs.FS)
+	return ok && fs.Encrypted()
+}
+
+// EnableDeduplication stores files with the same content only once. Files
+// stored before are not deduplicated. Directory stores are not supported.
+func (store *ForensicStore) EnableDeduplication() error {
+	fs, ok := store.Fs.(*sqlitefs.FS)
+	if !ok {
+		return errors.New("deduplication is only supported for sqlite stores")
+	}
+	return fs.EnableDeduplication()
+}
+
 func (store *ForensicStore) SetFS(fs afero.Fs) {
 	store.Fs = fs
 }
 
+// Connection returns the underlying sqlite connection. It returns nil for
+// stores opened with OpenPool, which use a connection per operation. The store
+// passed to the function of WithTx is bound to a single connection.
 func (store *ForensicStore) Connection() *sqlite.Conn {
 	return store.connection
 }
@@ -261,71 +390,105 @@ func (store *ForensicStore) Connection() *sqlite.Conn {
 
 // Insert adds a single element.
 func (store *ForensicStore) Insert(element JSONElement) (string, error) {
-	// validate element
-	valErr, err := validateSchema(element)
-	if err != nil {
-		return "", fmt.Errorf("validation failed: %w", err)
-	}
-	if len(valErr) > 0 {
-		return "", fmt.Errorf("element could not be validated [%s]", strings.Join(valErr, ","))
-	}
+	return store.InsertContext(context.Background(), element)
+}
 
-	// unmarshal element
-	nestedElement := map[string]interface{}{}
-	err = json.Unmarshal(element, &nestedElement)
-	if err != nil {
-		return "", err
-	}
+// InsertContext is like Insert, but aborts if ctx is done.
+func (store *ForensicStore) InsertContext(ctx context.Context, element JSONElement) (id string, err error) {
+	return store.insert(ctx, element, time.Now().UTC().Format(time.RFC3339Nano))
+}
 
-	elementType, ok := nestedElement["type"]
-	if !ok {
-		return "", errors.New("element requires type")
+// insert adds an element with the given insert time, e.g. the insert time of
+// a merged element in its source store. The current time is used if
+// insertTime is empty.
+func (store *ForensicStore) insert(ctx context.Context, element JSONElement, insertTime string) (id string, err error) {
+	if insertTime == "" {
+		insertTime = time.Now().UTC().Format(time.RFC3339Nano)
 	}
-	if _, ok := nestedElement[elementType.(string)]; ok {
-		return "", fmt.Errorf("element must not contain a field '%s'", elementType)
+	element, nestedElement, err := parseElement(element, "")
+	if err != nil {
+		return "", err
 	}
-	id, ok := nestedElement["id"]
-	if !ok {
-		id = elementType.(string) + "--" + uuid.New().String()
-		nestedElement["id"] = id
+	id = nestedElement["id"].(string)
 
-		element, err = json.Marshal(nestedElement)
-		if err != nil {
-			return "", err
-		}
+	conn, release, err := store.acquire(ctx, true)
+	if err != nil {
+		return "", err
 	}
-
-	store.types.addAll(elementType.(string), nestedElement)
+	defer release(&err)
+	defer sqlitex.Save(conn)(&err)
 
 	// insert into elements table
-	query := fmt.Sprintf("INSERT INTO `elements` (id, json, insert_time) VALUES ($id, $json, $time)") // #nosec
-	stmt, err := store.connection.Prepare(query)
+	stmt, err := conn.Prepare(insertQuery)
 	if err != nil {
-		return "", fmt.Errorf("could not prepare statement %s: %w", query, err)
+		return "", fmt.Errorf("could not prepare statement %s: %w", insertQuery, err)
 	}
-	stmt.SetText("$id", id.(string))
-	stmt.SetText("$json", string(element))
-	stmt.SetText("$time", time.Now().UTC().Format(time.RFC3339Nano))
-	_, err = stmt.Step()
+	err = insertElement(stmt, id, element, insertTime)
+	if err != nil {
+		return "", err
+	}
+	err = store.auditElement(conn, AuditInsert, id, nil, element)
 	if err != nil {
-		return "", fmt.Errorf("could not exec statement %s: %w", query, err)
+		return "", err
 	}
 
-	return id.(string), nil
+	store.types.addAll(nestedElement[discriminator].(string), nestedElement)
+	return id, nil
 }
 
-// InsertBatch adds a set of elements. All elements must have the same fields.
-func (store *ForensicStore) InsertBatch(elements []JSONElement) ([]string, error) { // nolint:gocyclo,funlen
+// InsertBatch adds a set of elements in a single transaction. If any element
+// cannot be inserted, none of the elements are inserted and a *BatchError
+// is returned.
+func (store *ForensicStore) InsertBatch(elements []JSONElement) (ids []string, err error) {
+	return store.InsertBatchContext(context.Background(), elements)
+}
+
+// InsertBatchContext is like InsertBatch, but aborts and rolls back if ctx is
+// done.
+func (store *ForensicStore) InsertBatchContext(ctx context.Context, elements []JSONElement) (ids []string, err error) {
 	if len(elements) == 0 {
 		return nil, nil
 	}
-	var ids []string
-	for _, element := range elements {
-		id, err := store.Insert(element)
+
+	var nestedElements []map[string]interface{}
+	err = store.WithTxContext(ctx, func(tx *ForensicStore) error {
+		stmt, err := tx.connection.Prepare(insertQuery)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("could not prepare statement %s: %w", insertQuery, err)
 		}
-		ids = append(ids, id)
+
+		for i, element := range elements {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			element, nestedElement, err := parseElement(element, "")
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+			id := nestedElement["id"].(string)
+
+			err = insertElement(stmt, id, element, time.Now().UTC().Format(time.RFC3339Nano))
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+			err = tx.auditElement(tx.connection, AuditInsert, id, nil, element)
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+
+			ids = append(ids, id)
+			nestedElements = append(nestedElements, nestedElement)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// only add fields of committed elements
+	for _, nestedElement := range nestedElements {
+		store.types.addAll(nestedElement[discriminator].(string), nestedElement)
 	}
 	return ids, nil
 }
@@ -342,12 +505,12 @@ func (store *ForensicStore) InsertStruct(element interface{}) (string, error) {
 // InsertStructBatch adds a list of structs to the forensicstore.
 func (store *ForensicStore) InsertStructBatch(elements []interface{}) ([]string, error) {
 	var ms []JSONElement
-	for _, element := range elements {
+	for i, element := range elements {
 		m := structs.Map(element)
 		m = lower(m).(map[string]interface{})
 		b, err := json.Marshal(m)
 		if err != nil {
-			return nil, err
+			return nil, &BatchError{Index: i, Err: err}
 		}
 		ms = append(ms, b)
 	}
@@ -357,35 +520,183 @@ func (store *ForensicStore) InsertStructBatch(elements []interface{}) ([]string,
 
 // Get retreives a single element.
 func (store *ForensicStore) Get(id string) (element JSONElement, err error) {
-	stmt, err := store.connection.Prepare(fmt.Sprintf("SELECT json FROM `elements` WHERE id=?")) // #nosec
+	return store.GetContext(context.Background(), id)
+}
+
+// GetContext is like Get, but aborts if ctx is done.
+func (store *ForensicStore) GetContext(ctx context.Context, id string) (element JSONElement, err error) {
+	rows, err := store.queryRows(ctx, "SELECT json FROM `elements` WHERE id=?", func(stmt *sqlite.Stmt) {
+		stmt.BindText(1, id)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	stmt.BindText(1, id)
-
-	elements, err := store.rowsToElements(stmt)
+	elements, err := rows.collect()
 	if err != nil {
 		return nil, err
 	}
 	if len(elements) > 0 {
 		return elements[0], nil
 	}
-	return nil, errors.New("element does not exist")
+	return nil, ErrElementNotExists
+}
+
+// Update replaces an existing element. The element must keep its type, an id
+// in the element must match the given id.
+func (store *ForensicStore) Update(id string, element JSONElement) error {
+	element, nestedElement, err := parseElement(element, id)
+	if err != nil {
+		return err
+	}
+	elementType := nestedElement[discriminator].(string)
+
+	err = store.WithTx(func(tx *ForensicStore) error {
+		old, err := tx.Get(id)
+		if err != nil {
+			return err
+		}
+		if oldType := gjson.GetBytes(old, discriminator).String(); oldType != elementType {
+			return fmt.Errorf("element type must not change (is %s, was %s)", elementType, oldType)
+		}
+
+		stmt, err := tx.connection.Prepare("UPDATE `elements` SET json = $json WHERE id = $id")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$id", id)
+		stmt.SetText("$json", string(element))
+		_, err = stmt.Step()
+		if err != nil {
+			return err
+		}
+		err = stmt.Finalize()
+		if err != nil {
+			return err
+		}
+		return tx.auditElement(tx.connection, AuditUpdate, id, old, element)
+	})
+	if err != nil {
+		return err
+	}
+
+	store.types.addAll(elementType, nestedElement)
+	return nil
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to an existing element.
+func (store *ForensicStore) Patch(id string, mergePatch JSONElement) error {
+	var patch interface{}
+	if err := json.Unmarshal(mergePatch, &patch); err != nil {
+		return err
+	}
+
+	return store.WithTx(func(tx *ForensicStore) error {
+		old, err := tx.Get(id)
+		if err != nil {
+			return err
+		}
+
+		var target interface{}
+		if err = json.Unmarshal(old, &target); err != nil {
+			return err
+		}
+
+		element, err := json.Marshal(applyMergePatch(target, patch))
+		if err != nil {
+			return err
+		}
+		return tx.Update(id, element)
+	})
+}
+
+// Delete removes an element. If removeFiles is set, files referenced in
+// "*_path" fields are removed from the store as well, unless they are
+// referenced by another element.
+func (store *ForensicStore) Delete(id string, removeFiles bool) error {
+	return store.WithTx(func(tx *ForensicStore) error {
+		element, err := tx.Get(id)
+		if err != nil {
+			return err
+		}
+
+		if removeFiles {
+			err = tx.removeElementFiles(tx.connection, id, element)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = deleteAnnotations(tx.connection, id)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.connection.Prepare("DELETE FROM `elements` WHERE id = $id")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$id", id)
+		_, err = stmt.Step()
+		if err != nil {
+			return err
+		}
+		err = stmt.Finalize()
+		if err != nil {
+			return err
+		}
+		return tx.auditElement(tx.connection, AuditDelete, id, element, nil)
+	})
 }
 
 // Query executes a sql query.
 func (store *ForensicStore) Query(query string) (elements []JSONElement, err error) {
-	stmt, err := store.connection.Prepare(query)
+	return store.QueryContext(context.Background(), query)
+}
+
+// QueryContext is like Query, but aborts if ctx is done.
+func (store *ForensicStore) QueryContext(ctx context.Context, query string) (elements []JSONElement, err error) {
+	rows, err := store.QueryRowsContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	return rows.collect()
+}
+
+// QueryRows executes a sql query and returns a cursor over the resulting
+// elements. The query must return a json column.
+func (store *ForensicStore) QueryRows(query string) (*Rows, error) {
+	return store.QueryRowsContext(context.Background(), query)
+}
+
+// QueryRowsContext is like QueryRows, but the cursor aborts if ctx is done.
+func (store *ForensicStore) QueryRowsContext(ctx context.Context, query string) (*Rows, error) {
+	return store.queryRows(ctx, query, nil)
+}
 
-	return store.rowsToElements(stmt)
+// Iterate executes a sql query and calls fn for every resulting element. The
+// iteration stops if fn returns an error.
+func (store *ForensicStore) Iterate(query string, fn func(element JSONElement) error) error {
+	return store.IterateContext(context.Background(), query, fn)
+}
+
+// IterateContext is like Iterate, but aborts if ctx is done.
+func (store *ForensicStore) IterateContext(ctx context.Context, query string, fn func(element JSONElement) error) error {
+	rows, err := store.QueryRowsContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows.Element()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 // StoreFile adds a file to the database folder.
-func (store *ForensicStore) StoreFile(filePath string) (storePath string, file io.WriteCloser, teardown func() error, err error) {
+func (store *ForensicStore) StoreFile(filePath string, options ...StoreFileOption) (storePath string, file io.WriteCloser, teardown func() error, err error) { // nolint:lll
 	err = store.Fs.MkdirAll(filepath.Dir(filePath), 0755)
 	if err != nil {
 		return "", nil, nil, err
@@ -396,21 +707,54 @@ func (store *ForensicStore) StoreFile(filePath string) (storePath string, file i
 	remoteStoreFilePath := filePath
 	base := remoteStoreFilePath[:len(remoteStoreFilePath)-len(ext)]
 
-	exists, err := afero.Exists(store.Fs, remoteStoreFilePath)
-	if err != nil {
-		return "", nil, nil, err
-	}
-	for exists {
-		remoteStoreFilePath = fmt.Sprintf("%s_%d%s", base, i, ext)
-		i++
-		exists, err = afero.Exists(store.Fs, remoteStoreFilePath)
+	var f afero.File
+	for {
+		exists, err := afero.Exists(store.Fs, remoteStoreFilePath)
 		if err != nil {
 			return "", nil, nil, err
 		}
+		if !exists {
+			// the name check and the creation are not atomic in pooled mode,
+			// so a concurrent StoreFile can create the file in between
+			f, err = store.Fs.OpenFile(remoteStoreFilePath, os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
+			if err == nil {
+				break
+			}
+			if !isFileExists(err) {
+				return "", nil, nil, err
+			}
+		}
+		remoteStoreFilePath = numberedPath(base, ext, i)
+		i++
 	}
 
-	file, err = store.Fs.Create(remoteStoreFilePath)
-	return remoteStoreFilePath, file, file.Close, err
+	auditedFile := &auditFile{File: f, store: store, path: remoteStoreFilePath, hash: sha256.New()}
+
+	var fileOptions storeFileOptions
+	for _, option := range options {
+		option(&fileOptions)
+	}
+	if fileOptions.hashed != nil {
+		*fileOptions.hashed = newHashedFile(path.Base(filePath), remoteStoreFilePath)
+		auditedFile.hashed = fileOptions.hashed
+	}
+	return remoteStoreFilePath, auditedFile, auditedFile.Close, nil
+}
+
+// isFileExists returns whether err was caused by creating a file that
+// already exists.
+func isFileExists(err error) bool {
+	var sqliteErr sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return os.IsExist(err)
+}
+
+// numberedPath returns the i-th alternative path for a file that already
+// exists, e.g. "dir/file_0.txt".
+func numberedPath(base, ext string, i int) string {
+	return fmt.Sprintf("%s_%d%s", base, i, ext)
 }
 
 // LoadFile opens a file from the database folder.
@@ -425,6 +769,9 @@ func (store *ForensicStore) Close() error {
 		_ = store.createViews()
 	}
 
+	if store.pool != nil {
+		return store.pool.Close()
+	}
 	return store.connection.Close()
 }
 
@@ -452,21 +799,86 @@ func (store *ForensicStore) createViews() error {
 	return nil
 }
 
+/* ################################
+#   Transactions
+################################ */
+
+// Begin starts a transaction. Elements and files stored in the sqlite archive
+// share the same connection, so both are part of the transaction. Files of
+// stores created with NewDirFS are written directly to disk and are not
+// affected by Commit or Rollback. Stores opened with OpenPool need to use
+// WithTx instead.
+func (store *ForensicStore) Begin() error {
+	if store.pool != nil {
+		return ErrPooledTransaction
+	}
+	return store.exec("BEGIN")
+}
+
+// Commit commits the transaction started with Begin.
+func (store *ForensicStore) Commit() error {
+	if store.pool != nil {
+		return ErrPooledTransaction
+	}
+	return store.exec("COMMIT")
+}
+
+// Rollback discards all changes since Begin.
+func (store *ForensicStore) Rollback() error {
+	if store.pool != nil {
+		return ErrPooledTransaction
+	}
+	return store.exec("ROLLBACK")
+}
+
+// WithTx runs fn in a transaction. The transaction is committed if fn returns
+// nil and rolled back if fn returns an error or panics. WithTx can be nested
+// and used within Begin and Commit.
+//
+// For stores opened with OpenPool, tx is bound to a single connection and
+// holds the write lock, so fn must only use tx to access the store.
+func (store *ForensicStore) WithTx(fn func(tx *ForensicStore) error) (err error) {
+	return store.WithTxContext(context.Background(), fn)
+}
+
+// WithTxContext is like WithTx, but aborts and rolls back if ctx is done.
+func (store *ForensicStore) WithTxContext(ctx context.Context, fn func(tx *ForensicStore) error) (err error) {
+	conn, release, err := store.acquire(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	tx, err := store.bind(conn)
+	if err != nil {
+		return err
+	}
+
+	defer sqlitex.Save(conn)(&err)
+	return fn(tx)
+}
+
 /* ################################
 #   Validate
 ################################ */
 
 // Validate checks the database for various flaws.
 func (store *ForensicStore) Validate() (flaws []string, err error) {
+	return store.ValidateContext(context.Background())
+}
+
+// ValidateContext is like Validate, but aborts if ctx is done.
+func (store *ForensicStore) ValidateContext(ctx context.Context) (flaws []string, err error) {
 	flaws = []string{}
 	expectedFiles := map[string]bool{}
 
-	elements, err := store.All()
+	rows, err := store.AllRowsContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, element := range elements {
-		validationErrors, elementExpectedFiles, err := store.validateElement(element)
+	defer rows.Close()
+	for rows.Next() {
+		validationErrors, elementExpectedFiles, err := store.validateElement(rows.Element())
 		if err != nil {
 			return nil, err
 		}
@@ -475,10 +887,28 @@ func (store *ForensicStore) Validate() (flaws []string, err error) {
 			expectedFiles[filepath.ToSlash(elementExpectedFile)] = true
 		}
 	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	relationshipFlaws, err := store.danglingRelationships(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flaws = append(flaws, relationshipFlaws...)
+
+	custodyFlaws, err := store.custodyFlaws(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flaws = append(flaws, custodyFlaws...)
 
 	foundFiles := map[string]bool{}
 	var additionalFiles []string
 	err = afero.Walk(store.Fs, "/", func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		path = filepath.ToSlash(path)
 		if info == nil || info.IsDir() {
 			return nil
@@ -602,38 +1032,52 @@ func (store *ForensicStore) validateElement(element JSONElement) (flaws []string
 
 // Select retrieves all elements of a discriminated attribute.
 func (store *ForensicStore) Select(conditions []map[string]string) (elements []JSONElement, err error) {
-	var ors []string
-	for _, condition := range conditions {
-		var ands []string
-		for key, value := range condition {
-			ands = append(ands, fmt.Sprintf("json_extract(json, '$.%s') LIKE '%s'", key, value))
-		}
-		if len(ands) > 0 {
-			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
-		}
-	}
-
-	query := "SELECT json FROM \"elements\""
-	if len(ors) > 0 {
-		query += fmt.Sprintf(" WHERE %s", strings.Join(ors, " OR ")) // #nosec
-	}
+	return store.SelectContext(context.Background(), conditions)
+}
 
-	stmt, err := store.connection.Prepare(query) // #nosec
+// SelectContext is like Select, but aborts if ctx is done.
+func (store *ForensicStore) SelectContext(ctx context.Context, conditions []map[string]string) (elements []JSONElement, err error) { // nolint:lll
+	rows, err := store.SelectRowsContext(ctx, conditions)
 	if err != nil {
 		return nil, err
 	}
+	return rows.collect()
+}
+
+// SelectRows is like Select, but returns a cursor over the elements.
+func (store *ForensicStore) SelectRows(conditions []map[string]string) (*Rows, error) {
+	return store.SelectRowsContext(context.Background(), conditions)
+}
 
-	return store.rowsToElements(stmt)
+// SelectRowsContext is like SelectRows, but the cursor aborts if ctx is done.
+func (store *ForensicStore) SelectRowsContext(ctx context.Context, conditions []map[string]string) (*Rows, error) {
+	return store.SelectWhereRowsContext(ctx, likeConditions(conditions)...)
 }
 
 // Search for elements.
 func (store *ForensicStore) Search(q string) (elements []JSONElement, err error) {
-	stmt, err := store.connection.Prepare("SELECT json FROM elements WHERE json LIKE $query")
+	return store.SearchContext(context.Background(), q)
+}
+
+// SearchContext is like Search, but aborts if ctx is done.
+func (store *ForensicStore) SearchContext(ctx context.Context, q string) (elements []JSONElement, err error) {
+	rows, err := store.SearchRowsContext(ctx, q)
 	if err != nil {
 		return nil, err
 	}
-	stmt.SetText("$query", "%"+q+"%")
-	return store.rowsToElements(stmt)
+	return rows.collect()
+}
+
+// SearchRows is like Search, but returns a cursor over the elements.
+func (store *ForensicStore) SearchRows(q string) (*Rows, error) {
+	return store.SearchRowsContext(context.Background(), q)
+}
+
+// SearchRowsContext is like SearchRows, but the cursor aborts if ctx is done.
+func (store *ForensicStore) SearchRowsContext(ctx context.Context, q string) (*Rows, error) {
+	return store.queryRows(ctx, "SELECT json FROM elements WHERE json LIKE $query", func(stmt *sqlite.Stmt) {
+		stmt.SetText("$query", "%"+q+"%")
+	})
 }
 
 // All returns every element.
@@ -641,31 +1085,182 @@ func (store *ForensicStore) All() (elements []JSONElement, err error) {
 	return store.Select(nil)
 }
 
+// AllContext is like All, but aborts if ctx is done.
+func (store *ForensicStore) AllContext(ctx context.Context) (elements []JSONElement, err error) {
+	return store.SelectContext(ctx, nil)
+}
+
+// AllRows returns a cursor over every element.
+func (store *ForensicStore) AllRows() (*Rows, error) {
+	return store.SelectRows(nil)
+}
+
+// AllRowsContext is like AllRows, but the cursor aborts if ctx is done.
+func (store *ForensicStore) AllRowsContext(ctx context.Context) (*Rows, error) {
+	return store.SelectRowsContext(ctx, nil)
+}
+
 /* ################################
 #   Intern
 ################################ */
 
-func (store *ForensicStore) rowsToElements(stmt *sqlite.Stmt) (elements []JSONElement, err error) {
-	elements = []JSONElement{}
-	for {
-		if hasRow, err := stmt.Step(); err != nil {
-			return nil, err
-		} else if !hasRow {
-			break
+// parseElement validates an element and ensures it contains an id. If id is
+// set, the element id must either be missing or equal to it.
+func parseElement(element JSONElement, id string) (JSONElement, map[string]interface{}, error) {
+	// unmarshal element
+	nestedElement := map[string]interface{}{}
+	err := json.Unmarshal(element, &nestedElement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if elementID, ok := nestedElement["id"]; ok && id != "" && elementID != id {
+		return nil, nil, fmt.Errorf("element id %v does not match %s", elementID, id)
+	} else if !ok && id != "" {
+		nestedElement["id"] = id
+		element, err = json.Marshal(nestedElement)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// validate element
+	valErr, err := validateSchema(element)
+	if err != nil {
+		return nil, nil, fmt.Errorf("validation failed: %w", err)
+	}
+	if len(valErr) > 0 {
+		return nil, nil, fmt.Errorf("element could not be validated [%s]", strings.Join(valErr, ","))
+	}
+
+	elementType, ok := nestedElement[discriminator].(string)
+	if !ok {
+		return nil, nil, errors.New("element requires type")
+	}
+	if _, ok := nestedElement[elementType]; ok {
+		return nil, nil, fmt.Errorf("element must not contain a field '%s'", elementType)
+	}
+
+	if elementID, ok := nestedElement["id"]; ok {
+		if _, ok := elementID.(string); !ok {
+			return nil, nil, errors.New("element id must be a string")
+		}
+		return element, nestedElement, nil
+	}
+
+	nestedElement["id"] = elementType + "--" + uuid.New().String()
+	element, err = json.Marshal(nestedElement)
+	if err != nil {
+		return nil, nil, err
+	}
+	return element, nestedElement, nil
+}
+
+// insertElement executes a prepared insert statement for a single element.
+func insertElement(stmt *sqlite.Stmt, id string, element JSONElement, insertTime string) error {
+	err := stmt.Reset()
+	if err != nil {
+		return err
+	}
+	stmt.SetText("$id", id)
+	stmt.SetText("$json", string(element))
+	stmt.SetText("$time", insertTime)
+	_, err = stmt.Step()
+	if err != nil {
+		return fmt.Errorf("could not exec statement %s: %w", insertQuery, err)
+	}
+	return nil
+}
+
+// removeElementFiles removes all files referenced by an element, that are not
+// referenced by any other element.
+func (store *ForensicStore) removeElementFiles(conn *sqlite.Conn, id string, element JSONElement) error {
+	var fields map[string]interface{}
+	err := json.Unmarshal(element, &fields)
+	if err != nil {
+		return err
+	}
+
+	for field, value := range fields {
+		exportPath, ok := value.(string)
+		if !strings.HasSuffix(field, "_path") || !ok {
+			continue
+		}
+
+		stmt, err := conn.Prepare("SELECT count(*) AS refs FROM elements, json_each(elements.json) " +
+			"WHERE elements.id != $id AND json_each.key LIKE '%\\_path' ESCAPE '\\' AND json_each.value = $path")
+		if err != nil {
+			return err
+		}
+		stmt.SetText("$id", id)
+		stmt.SetText("$path", exportPath)
+		_, err = stmt.Step()
+		if err != nil {
+			return err
+		}
+		refs := stmt.GetInt64("refs")
+		err = stmt.Finalize()
+		if err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+
+		before, err := hashFile(store.Fs, exportPath)
+		if err != nil {
+			return err
+		}
+		if before == "" {
+			continue
+		}
+		err = store.Fs.Remove(exportPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = store.audit(conn, AuditRemoveFile, "", exportPath, before, "")
+		if err != nil {
+			return err
 		}
-		elements = append(elements, JSONElement(stmt.GetText("json")))
 	}
-	return elements, stmt.Finalize()
+	return nil
 }
 
+// queryRows prepares a query, binds parameters with bind and returns a cursor
+// over the resulting elements.
+func (store *ForensicStore) queryRows(ctx context.Context, query string, bind func(stmt *sqlite.Stmt)) (rows *Rows, err error) { // nolint:lll
+	conn, release, err := store.acquire(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// transient statements are not cached by the connection, so multiple
+	// statements of the same query can be stepped at the same time
+	stmt, trailingBytes, err := conn.PrepareTransient(query)
+	if err == nil && trailingBytes != 0 {
+		_ = stmt.Finalize()
+		err = fmt.Errorf("query has trailing bytes: %s", query)
+	}
+	if err != nil {
+		release(&err)
+		return nil, err
+	}
+
+	if bind != nil {
+		bind(stmt)
+	}
+	return newRows(stmt, release), nil
+}
+
+// isElementTable returns whether an entry of sqlite_master is the view of an
+// element type. Internal tables of the store are prefixed with an underscore,
+// so they do not collide with the view of an element type.
 func isElementTable(name string) bool {
 	if strings.HasPrefix(name, "sqlite") || strings.HasPrefix(name, "_") {
 		return false
 	}
-	if name == "sqlar" {
-		return false
-	}
-	if name == "elements" {
+	switch name {
+	case "sqlar", "sqlar_encryption", "sqlar_blobs", "sqlar_refs", "elements", ftsTable:
 		return false
 	}
 
@@ -677,8 +1272,14 @@ func isElementTable(name string) bool {
 	return true
 }
 
-func (store *ForensicStore) setupTypes() error {
-	stmt, err := store.connection.Prepare("SELECT name FROM sqlite_master")
+func (store *ForensicStore) setupTypes() (err error) {
+	conn, release, err := store.acquire(context.Background(), false)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	stmt, err := conn.Prepare("SELECT name FROM sqlite_master")
 	if err != nil {
 		return err
 	}
@@ -696,7 +1297,7 @@ func (store *ForensicStore) setupTypes() error {
 			continue
 		}
 
-		pragmaStmt, err := store.connection.Prepare(fmt.Sprintf("PRAGMA table_info (\"%s\")", name))
+		pragmaStmt, err := conn.Prepare(fmt.Sprintf("PRAGMA table_info (\"%s\")", name))
 		if err != nil {
 			return err
 		}
@@ -720,8 +1321,14 @@ func (store *ForensicStore) setupTypes() error {
 	return stmt.Finalize()
 }
 
-func (store *ForensicStore) exec(query string) error {
-	stmt, err := store.connection.Prepare(query)
+func (store *ForensicStore) exec(query string) (err error) {
+	conn, release, err := store.acquire(context.Background(), true)
+	if err != nil {
+		return err
+	}
+	defer release(&err)
+
+	stmt, err := conn.Prepare(query)
 	if err != nil {
 		return err
 	}
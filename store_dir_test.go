@@ -30,6 +30,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -448,6 +449,27 @@ func TestDirStore_Validate(t *testing.T) {
 	}
 }
 
+func TestDirStore_Delete(t *testing.T) {
+	store, teardown := setupDirUrl(t)
+	defer teardown()
+
+	err := store.Delete("process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/WMILogicalDisks/stdout", "/WMILogicalDisks/stderr"} {
+		exists, _ := afero.Exists(store.Fs, name)
+		assert.False(t, exists, name)
+	}
+
+	gotE, err := store.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{}, gotE)
+}
+
 func TestDirStore_StoreFile(t *testing.T) {
 	store, teardown := setup(t)
 	defer teardown()
@@ -23,8 +23,12 @@
 package forensicstore
 
 import (
+	"context"
 	"crawshaw.io/sqlite"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"io"
 	"github.com/spf13/afero"
 	"io/ioutil"
 	"log"
@@ -33,8 +37,10 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
 )
 
 func init() {
@@ -299,6 +305,55 @@ func TestStore_Insert(t *testing.T) {
 	}
 }
 
+func TestStore_InsertBatch(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	foo := jsons(element{"name": "foo", "type": "fo", "int": 0})
+	bar := jsons(element{"name": "bar", "type": "ba", "int": 2})
+	invalid := jsons(element{"name": "invalid"})
+
+	type args struct {
+		elements []JSONElement
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantCount int
+		wantIndex int
+		wantErr   bool
+	}{
+		{"Insert batch", args{[]JSONElement{foo, bar}}, 9, 0, false},
+		{"Insert empty batch", args{nil}, 9, 0, false},
+		{"Insert invalid batch", args{[]JSONElement{foo, invalid, bar}}, 9, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.InsertBatch(tt.args.elements)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.InsertBatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				var batchErr *BatchError
+				if !errors.As(err, &batchErr) {
+					t.Fatalf("ForensicStore.InsertBatch() error = %T, want *BatchError", err)
+				}
+				assert.Equal(t, tt.wantIndex, batchErr.Index)
+				assert.Nil(t, got)
+			} else {
+				assert.Equal(t, len(tt.args.elements), len(got))
+			}
+
+			elements, err := store.All()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.wantCount, len(elements))
+		})
+	}
+}
+
 func TestForensicStore_InsertStruct(t *testing.T) {
 	store, teardown := setup(t)
 	defer teardown()
@@ -438,6 +493,9 @@ func TestStore_Select(t *testing.T) {
 		{"Select", args{[]map[string]string{{"type": "file"}}}, 2, false},
 		{"Select with filter", args{[]map[string]string{{"type": "file", "name": "foo.doc"}}}, 1, false},
 		{"Select not existing", args{[]map[string]string{{"type": "xxx"}}}, 0, false},
+		{"Select quote", args{[]map[string]string{{"name": "foo'.doc"}}}, 0, false},
+		{"Select injection", args{[]map[string]string{{"type": "x' OR '1'='1"}}}, 0, false},
+		{"Select invalid field", args{[]map[string]string{{"type') OR 1=1 --": "file"}}}, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -476,6 +534,271 @@ func TestStore_All(t *testing.T) {
 	}
 }
 
+func TestStore_Update(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	updated := jsons(element{"id": ProcessElementId, "type": "process", "name": "ip6tables", "new_field": "foo"})
+	noID := jsons(element{"type": "process", "name": "ip6tables"})
+	otherID := jsons(element{"id": "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d", "type": "process"})
+	otherType := jsons(element{"id": ProcessElementId, "type": "file", "name": "foo"})
+
+	type args struct {
+		id      string
+		element JSONElement
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"Update", args{ProcessElementId, updated}, false},
+		{"Update without id", args{ProcessElementId, noID}, false},
+		{"Update with other id", args{ProcessElementId, otherID}, true},
+		{"Update with other type", args{ProcessElementId, otherType}, true},
+		{"Update non existing", args{"process--16b02a2b-d1a1-4e79-aad6-2f2c1c286818", noID}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Update(tt.args.id, tt.args.element)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.Update() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			got, err := store.Get(tt.args.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want map[string]interface{}
+			if err := json.Unmarshal(tt.args.element, &want); err != nil {
+				t.Fatal(err)
+			}
+			want["id"] = tt.args.id
+			assert.JSONEq(t, string(jsons(want)), string(got))
+		})
+	}
+
+	if !store.types.all()["process"]["new_field"] {
+		t.Errorf("ForensicStore.Update() new field not added to types")
+	}
+}
+
+func TestStore_Patch(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	type args struct {
+		id    string
+		patch JSONElement
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"Patch", args{ProcessElementId, []byte(`{"name": "ip6tables", "cwd": null}`)}, map[string]interface{}{"name": "ip6tables"}, false},
+		{"Patch id", args{ProcessElementId, []byte(`{"id": "process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d"}`)}, nil, true},
+		{"Patch invalid json", args{ProcessElementId, []byte(`{`)}, nil, true},
+		{"Patch non existing", args{"process--16b02a2b-d1a1-4e79-aad6-2f2c1c286818", []byte(`{}`)}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Patch(tt.args.id, tt.args.patch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.Patch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := store.Get(tt.args.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotMap map[string]interface{}
+			if err := json.Unmarshal(got, &gotMap); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.want {
+				assert.Equal(t, value, gotMap[key])
+			}
+			assert.NotContains(t, gotMap, "cwd")
+			assert.Equal(t, "IPTablesRules", gotMap["artifact"])
+		})
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	type args struct {
+		id          string
+		removeFiles bool
+	}
+	tests := []struct {
+		name       string
+		args       args
+		removed    []string
+		notRemoved []string
+		wantErr    bool
+	}{
+		{"Delete", args{ProcessElementId, false}, nil, []string{"/IPTablesRules/stdout", "/IPTablesRules/stderr"}, false},
+		{"Delete with files", args{"process--9da4aa39-53b8-412e-b3cd-6b26c772ad4d", true}, []string{"/WMILogicalDisks/stdout", "/WMILogicalDisks/stderr"}, nil, false},
+		{"Delete with shared files", args{"file--ddc3b32f-a1ea-4888-87ca-5591773f6be3", true}, nil, []string{"/WindowsAMCacheHveFile/Amcache.hve"}, false},
+		{"Delete non existing", args{"process--16b02a2b-d1a1-4e79-aad6-2f2c1c286818", false}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Delete(tt.args.id, tt.args.removeFiles)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.Delete() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			if _, err := store.Get(tt.args.id); err != ErrElementNotExists {
+				t.Errorf("ForensicStore.Delete() element still exists")
+			}
+			for _, name := range tt.removed {
+				exists, _ := afero.Exists(store.Fs, name)
+				assert.False(t, exists, name)
+			}
+			for _, name := range tt.notRemoved {
+				exists, _ := afero.Exists(store.Fs, name)
+				assert.True(t, exists, name)
+			}
+		})
+	}
+}
+
+func TestStore_WithTx(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	tests := []struct {
+		name     string
+		fnErr    error
+		wantFile bool
+		wantErr  bool
+	}{
+		{"Commit", nil, true, false},
+		{"Rollback", errors.New("failed"), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exportPath, id string
+			err := store.WithTx(func(tx *ForensicStore) error {
+				var file io.WriteCloser
+				var fileTeardown func() error
+				var err error
+				exportPath, file, fileTeardown, err = tx.StoreFile("tx/" + tt.name + ".txt")
+				if err != nil {
+					return err
+				}
+				if _, err = file.Write([]byte("foo")); err != nil {
+					return err
+				}
+				if err = fileTeardown(); err != nil {
+					return err
+				}
+
+				id, err = tx.Insert(jsons(element{"type": "foo", "export_path": exportPath}))
+				if err != nil {
+					return err
+				}
+				return tt.fnErr
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ForensicStore.WithTx() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			_, err = store.Get(id)
+			assert.Equal(t, tt.wantFile, err == nil)
+			exists, err := afero.Exists(store.Fs, exportPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.wantFile, exists)
+		})
+	}
+}
+
+func TestStore_Begin(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	if err := store.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.Insert(jsons(element{"type": "foo"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(id); err != ErrElementNotExists {
+		t.Errorf("ForensicStore.Rollback() element still exists")
+	}
+
+	if err = store.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	id, err = store.Insert(jsons(element{"type": "foo"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(id); err != nil {
+		t.Errorf("ForensicStore.Commit() element missing: %s", err)
+	}
+}
+
+func TestStore_Context(t *testing.T) {
+	store, teardown := setup(t)
+	defer teardown()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	endless := "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT x AS json FROM c WHERE x < 0"
+
+	t.Run("Query timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := store.QueryContext(ctx, endless)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+	t.Run("Search canceled", func(t *testing.T) {
+		_, err := store.SearchContext(canceled, "IPTablesRules")
+		assert.Equal(t, context.Canceled, err)
+	})
+	t.Run("Validate canceled", func(t *testing.T) {
+		_, err := store.ValidateContext(canceled)
+		assert.Equal(t, context.Canceled, err)
+	})
+	t.Run("InsertBatch canceled", func(t *testing.T) {
+		_, err := store.InsertBatchContext(canceled, []JSONElement{jsons(element{"type": "foo"})})
+		assert.Equal(t, context.Canceled, err)
+	})
+	t.Run("Connection usable", func(t *testing.T) {
+		elements, err := store.All()
+		assert.NoError(t, err)
+		assert.Equal(t, 7, len(elements))
+	})
+}
+
 func TestStore_Validate(t *testing.T) {
 	store, teardown := setup(t)
 	defer teardown()
@@ -635,3 +958,79 @@ func TestForensicStore_Connection(t *testing.T) {
 		})
 	}
 }
+
+func TestForensicStore_internalTableTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := filepath.Join(dir, "internal.forensicstore")
+
+	// element types named like the internal tables
+	internal := []struct {
+		name string
+		use  func(store *ForensicStore, id string) error
+	}{
+		{"metadata", func(store *ForensicStore, id string) error {
+			return store.SetMetadata(MetadataExaminer, "alice")
+		}},
+		{"custody", func(store *ForensicStore, id string) error {
+			_, err := store.AddCustodyEvent(CustodyEvent{Action: CustodyAcquired, To: "alice"})
+			return err
+		}},
+		{"annotations", func(store *ForensicStore, id string) error {
+			_, err := store.AddAnnotation(id, "alice", "suspicious")
+			return err
+		}},
+		{"audit", func(store *ForensicStore, id string) error {
+			flaws, err := store.VerifyIntegrity()
+			if err == nil && len(flaws) > 0 {
+				err = errors.New(strings.Join(flaws, ", "))
+			}
+			return err
+		}},
+		{"labels", func(store *ForensicStore, id string) error {
+			_, err := store.SelectByLabel("reviewed")
+			return err
+		}},
+		{"signatures", func(store *ForensicStore, id string) error {
+			publicKey, privateKey, err := ed25519.GenerateKey(nil)
+			if err != nil {
+				return err
+			}
+			if err := store.Sign(privateKey); err != nil {
+				return err
+			}
+			return store.VerifySignature(publicKey)
+		}},
+	}
+
+	store, teardown, err := New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range internal {
+		id, err := store.Insert(jsons(element{"type": table.name, "name": table.name}))
+		assert.NoError(t, err)
+		assert.NoError(t, table.use(store, id), table.name)
+	}
+	assert.NoError(t, teardown())
+
+	store, teardown, err = Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+	for _, table := range internal {
+		elements, err := store.Select([]map[string]string{{"type": table.name}})
+		assert.NoError(t, err)
+		if assert.Len(t, elements, 1, table.name) {
+			assert.NoError(t, table.use(store, gjson.GetBytes(elements[0], "id").String()), table.name)
+		}
+
+		rows, err := store.Query("SELECT name FROM '" + table.name + "'")
+		assert.NoError(t, err, table.name)
+		assert.Len(t, rows, 1, table.name)
+	}
+}
@@ -78,3 +78,24 @@ func isEmptyValue(v reflect.Value) bool {
 	}
 	return false
 }
+
+// applyMergePatch applies a JSON merge patch as described in RFC 7396.
+func applyMergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = applyMergePatch(targetMap[key], value)
+		}
+	}
+	return targetMap
+}
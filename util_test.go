@@ -69,3 +69,32 @@ func Test_isEmptyValue(t *testing.T) {
 		})
 	}
 }
+
+func Test_applyMergePatch(t *testing.T) {
+	type args struct {
+		target interface{}
+		patch  interface{}
+	}
+	tests := []struct {
+		name string
+		args args
+		want interface{}
+	}{
+		{"Replace", args{map[string]interface{}{"a": "b"}, map[string]interface{}{"a": "c"}}, map[string]interface{}{"a": "c"}},
+		{"Add", args{map[string]interface{}{"a": "b"}, map[string]interface{}{"b": "c"}}, map[string]interface{}{"a": "b", "b": "c"}},
+		{"Remove", args{map[string]interface{}{"a": "b", "b": "c"}, map[string]interface{}{"a": nil}}, map[string]interface{}{"b": "c"}},
+		{"Nested", args{
+			map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": "e"}},
+			map[string]interface{}{"a": map[string]interface{}{"d": nil, "f": "g"}},
+		}, map[string]interface{}{"a": map[string]interface{}{"b": "c", "f": "g"}}},
+		{"Replace list", args{map[string]interface{}{"a": []interface{}{"b"}}, map[string]interface{}{"a": []interface{}{"c"}}}, map[string]interface{}{"a": []interface{}{"c"}}},
+		{"Non object patch", args{map[string]interface{}{"a": "b"}, "c"}, "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyMergePatch(tt.args.target, tt.args.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyMergePatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}